@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/websu-io/websu/pkg/api"
+	"log"
 	"os"
 )
 
@@ -10,7 +11,21 @@ func main() {
 	if mongoURI == "" {
 		mongoURI = "mongodb://localhost:27017"
 	}
+	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
+		level, err := api.ParseLogLevel(logLevel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		api.SetLogLevel("", level)
+	}
 	a := api.NewApp()
 	api.CreateMongoClient(mongoURI)
+	api.CreateIndexes()
+	api.CreateReadPreferences()
+	api.CreateScanStore()
+	a.StartBackgroundTasks()
+	if _, err := a.Workers.Recover(); err != nil {
+		log.Fatal(err)
+	}
 	a.Run(":8000")
 }
@@ -6,11 +6,13 @@ require (
 	cloud.google.com/go/storage v1.8.0
 	github.com/golang/protobuf v1.4.2 // indirect
 	github.com/gorilla/mux v1.7.4
+	github.com/gorilla/websocket v1.4.2
+	github.com/lib/pq v1.7.0
 	github.com/rs/cors v1.7.0
 	github.com/rs/xid v1.2.1
 	go.mongodb.org/mongo-driver v1.3.2
 	golang.org/x/mod v0.3.0 // indirect
-	golang.org/x/net v0.0.0-20200520182314-0ba52f642ac2 // indirect
+	golang.org/x/net v0.0.0-20200520182314-0ba52f642ac2
 	golang.org/x/sys v0.0.0-20200523222454-059865788121 // indirect
 	golang.org/x/tools v0.0.0-20200522201501-cb1345f3a375 // indirect
 	google.golang.org/api v0.25.0 // indirect
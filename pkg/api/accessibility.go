@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultAccessibilityIssueDays = 30
+
+// AccessibilityIssue is an element of a page that fails an accessibility
+// audit. Selector is empty for audits that don't point at elements.
+type AccessibilityIssue struct {
+	Audit    string `json:"audit" bson:"audit"`
+	Title    string `json:"title" bson:"title"`
+	Selector string `json:"selector,omitempty" bson:"selector,omitempty"`
+}
+
+// AccessibilityIssues returns the elements failing the audits of the
+// accessibility category.
+func (r *LighthouseReport) AccessibilityIssues() ([]AccessibilityIssue, error) {
+	issues := []AccessibilityIssue{}
+	for _, ref := range r.Categories["accessibility"].AuditRefs {
+		a, ok := r.Audits[ref.ID]
+		if !ok || a.Score == nil || *a.Score >= 1 {
+			continue
+		}
+		var items []struct {
+			Node struct {
+				Selector string `json:"selector"`
+			} `json:"node"`
+		}
+		if err := r.auditItems(ref.ID, &items); err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			issues = append(issues, AccessibilityIssue{Audit: ref.ID, Title: a.Title})
+		}
+		for _, item := range items {
+			issues = append(issues, AccessibilityIssue{Audit: ref.ID, Title: a.Title, Selector: item.Node.Selector})
+		}
+	}
+	return issues, nil
+}
+
+// AccessibilityIssueList is the deduplicated list of the accessibility
+// issues found on the URLs of a site group within the last Days days.
+type AccessibilityIssueList struct {
+	GroupID primitive.ObjectID          `json:"group_id"`
+	Days    int                         `json:"days"`
+	Issues  []TrackedAccessibilityIssue `json:"issues"`
+}
+
+// TrackedAccessibilityIssue is an issue as it was found across scans. An
+// issue is open while the latest scan of one of its URLs still has it.
+type TrackedAccessibilityIssue struct {
+	AccessibilityIssue
+	URLs        []string  `json:"urls"`
+	Occurrences int       `json:"occurrences"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Open        bool      `json:"open"`
+}
+
+// track aggregates the issues of scans, which must be sorted oldest first.
+func (l *AccessibilityIssueList) track(scans []Scan) {
+	tracked := make(map[AccessibilityIssue]*TrackedAccessibilityIssue)
+	urls := make(map[AccessibilityIssue]map[string]bool)
+	latest := make(map[string]primitive.ObjectID)
+	for _, scan := range scans {
+		latest[scan.URL] = scan.ID
+		for _, issue := range scan.AccessibilityIssues {
+			t, ok := tracked[issue]
+			if !ok {
+				t = &TrackedAccessibilityIssue{AccessibilityIssue: issue, FirstSeen: scan.CreatedAt}
+				tracked[issue] = t
+				urls[issue] = make(map[string]bool)
+			}
+			t.Occurrences++
+			t.LastSeen = scan.CreatedAt
+			urls[issue][scan.URL] = true
+		}
+	}
+	open := make(map[AccessibilityIssue]bool)
+	for _, scan := range scans {
+		if latest[scan.URL] != scan.ID {
+			continue
+		}
+		for _, issue := range scan.AccessibilityIssues {
+			open[issue] = true
+		}
+	}
+	l.Issues = []TrackedAccessibilityIssue{}
+	for issue, t := range tracked {
+		for url := range urls[issue] {
+			t.URLs = append(t.URLs, url)
+		}
+		sort.Strings(t.URLs)
+		t.Open = open[issue]
+		l.Issues = append(l.Issues, *t)
+	}
+	sort.Slice(l.Issues, func(i, j int) bool {
+		a, b := l.Issues[i], l.Issues[j]
+		if len(a.URLs) != len(b.URLs) {
+			return len(a.URLs) > len(b.URLs)
+		}
+		if a.Occurrences != b.Occurrences {
+			return a.Occurrences > b.Occurrences
+		}
+		if a.Audit != b.Audit {
+			return a.Audit < b.Audit
+		}
+		return a.Selector < b.Selector
+	})
+}
+
+func (l *AccessibilityIssueList) writeCSV(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=accessibility-issues.csv")
+	out := csv.NewWriter(w)
+	out.Write([]string{"audit", "title", "selector", "url_count", "urls", "occurrences", "first_seen", "last_seen", "open"})
+	for _, issue := range l.Issues {
+		out.Write([]string{
+			issue.Audit,
+			issue.Title,
+			issue.Selector,
+			strconv.Itoa(len(issue.URLs)),
+			// URLs can't contain spaces, so they separate them.
+			strings.Join(issue.URLs, " "),
+			strconv.Itoa(issue.Occurrences),
+			issue.FirstSeen.Format(time.RFC3339),
+			issue.LastSeen.Format(time.RFC3339),
+			strconv.FormatBool(issue.Open),
+		})
+	}
+	out.Flush()
+}
+
+// getGroupAccessibilityIssues lists the accessibility issues found on the
+// URLs of the group over the last ?days days, 30 by default, most
+// widespread first. ?format=csv exports the list as CSV.
+func (a *App) getGroupAccessibilityIssues(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeError(w, r, "Unknown format "+format+", expected json or csv", http.StatusBadRequest)
+		return
+	}
+	group, err := GetGroupByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	days, err := parseTrendQuery(r, defaultAccessibilityIssueDays)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l := AccessibilityIssueList{GroupID: group.ID, Days: days}
+	filter := bson.M{"url": bson.M{"$in": group.URLs}, "status": scoredScans}
+	scans, err := findTrendScans(QueryReports, filter, days)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	l.track(scans)
+	if format == "csv" {
+		l.writeCSV(w)
+		return
+	}
+	json.NewEncoder(w).Encode(&l)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxQueryResults caps the number of documents returned by POST /admin/query.
+const maxQueryResults = 1000
+
+// allowedQueryStages are the aggregation stages analysts may use. Stages
+// that write ($out, $merge) or read other collections ($lookup) are left
+// out on purpose.
+var allowedQueryStages = map[string]bool{
+	"$match":       true,
+	"$project":     true,
+	"$group":       true,
+	"$sort":        true,
+	"$limit":       true,
+	"$skip":        true,
+	"$count":       true,
+	"$unwind":      true,
+	"$addFields":   true,
+	"$sortByCount": true,
+}
+
+// forbiddenQueryOperators run server-side JavaScript and are rejected
+// anywhere in a pipeline.
+var forbiddenQueryOperators = map[string]bool{
+	"$where":       true,
+	"$function":    true,
+	"$accumulator": true,
+}
+
+type queryRequest struct {
+	Pipeline []json.RawMessage `json:"pipeline"`
+}
+
+// requireAdmin rejects requests that don't carry the configured admin token
+// as a bearer token. The admin endpoints are disabled when no token is set.
+func (a *App) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.AdminToken == "" {
+			writeError(w, r, "The admin endpoints are disabled, set ADMIN_TOKEN to enable them", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) != 1 {
+			writeError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// parseQueryPipeline converts the extended JSON stages of a query request
+// into an aggregation pipeline, rejecting stages and operators that are
+// not whitelisted. The result is always capped at maxQueryResults.
+func parseQueryPipeline(stages []json.RawMessage) ([]bson.D, error) {
+	pipeline := []bson.D{}
+	for i, raw := range stages {
+		var stage bson.D
+		if err := bson.UnmarshalExtJSON(raw, false, &stage); err != nil {
+			return nil, fmt.Errorf("Stage %d is not a valid document: %v", i, err)
+		}
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("Stage %d must contain exactly one operator", i)
+		}
+		if !allowedQueryStages[stage[0].Key] {
+			return nil, fmt.Errorf("Stage %d uses %s, which is not allowed", i, stage[0].Key)
+		}
+		if op := findForbiddenOperator(stage); op != "" {
+			return nil, fmt.Errorf("Stage %d uses %s, which is not allowed", i, op)
+		}
+		pipeline = append(pipeline, stage)
+	}
+	return append(pipeline, bson.D{{Key: "$limit", Value: maxQueryResults}}), nil
+}
+
+func findForbiddenOperator(v interface{}) string {
+	switch v := v.(type) {
+	case bson.D:
+		for _, e := range v {
+			if forbiddenQueryOperators[e.Key] {
+				return e.Key
+			}
+			if op := findForbiddenOperator(e.Value); op != "" {
+				return op
+			}
+		}
+	case bson.A:
+		for _, e := range v {
+			if op := findForbiddenOperator(e); op != "" {
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+func (a *App) queryScans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req queryRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	pipeline, err := parseQueryPipeline(req.Pipeline)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !scansInMongo() {
+		writeStoreError(w, r, ErrScansNotInMongo)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	collection := scansCollectionFor(QueryAdmin)
+	opts := options.Aggregate().SetMaxTime(30 * time.Second)
+	cursor, err := collection.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cursor.Close(ctx)
+	results := []json.RawMessage{}
+	for cursor.Next(ctx) {
+		doc, err := bson.MarshalExtJSON(cursor.Current, false, false)
+		if err != nil {
+			writeError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, doc)
+	}
+	if err := cursor.Err(); err != nil {
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Annotation is a review note on an audit of a URL, e.g. "accepted:
+// marketing pixel required". It applies to all scans of the URL until it
+// expires, and annotated audits are left out of scan comparisons.
+type Annotation struct {
+	ID  primitive.ObjectID `json:"id" bson:"_id"`
+	URL string             `json:"url" bson:"url"`
+	// ScanID is the scan the annotation was made on, GroupID the site
+	// group it was made for when the audit was acknowledged for the whole
+	// group.
+	ScanID    *primitive.ObjectID `json:"scan_id,omitempty" bson:"scan_id,omitempty"`
+	GroupID   *primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	Audit     string              `json:"audit" bson:"audit"`
+	Note      string              `json:"note" bson:"note"`
+	CreatedAt time.Time           `json:"created_at" bson:"created_at"`
+	// ExpiresAt is when the annotation stops applying, never when null.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+func (an *Annotation) validate() error {
+	if an.Audit == "" {
+		return errors.New("Annotation audit must not be empty")
+	}
+	if an.Note == "" {
+		return errors.New("Annotation note must not be empty")
+	}
+	if an.ExpiresAt != nil && an.ExpiresAt.Before(time.Now()) {
+		return errors.New("Annotation expires_at must be in the future")
+	}
+	return nil
+}
+
+// GetAnnotationsByURL returns the annotations of url that haven't expired.
+func GetAnnotationsByURL(url string) ([]Annotation, error) {
+	annotations := []Annotation{}
+	collection := DB.Database("websu").Collection("annotations")
+	c := context.TODO()
+	filter := bson.M{
+		"url": url,
+		"$or": []bson.M{
+			{"expires_at": bson.M{"$exists": false}},
+			{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	}
+	cursor, err := collection.Find(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &annotations); err != nil {
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// annotatedAudits returns the IDs of the annotated audits of url.
+func annotatedAudits(url string) (map[string]bool, error) {
+	annotations, err := GetAnnotationsByURL(url)
+	if err != nil {
+		return nil, err
+	}
+	audits := make(map[string]bool)
+	for _, an := range annotations {
+		audits[an.Audit] = true
+	}
+	return audits, nil
+}
+
+func GetAnnotationByObjectIDHex(hex string) (Annotation, error) {
+	var an Annotation
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return an, err
+	}
+	collection := DB.Database("websu").Collection("annotations")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&an)
+	return an, err
+}
+
+func (an *Annotation) Insert() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("annotations")
+	_, err := collection.InsertOne(ctx, an)
+	return err
+}
+
+func (an *Annotation) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("annotations")
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": an.ID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound("Annotation", an.ID)
+	}
+	return nil
+}
+
+// getScanAnnotations returns the annotations of the URL of the scan,
+// including those made on other scans of the URL.
+func (a *App) getScanAnnotations(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	scan, err := GetScanByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	annotations, err := GetAnnotationsByURL(scan.URL)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&annotations)
+}
+
+func (a *App) createScanAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	scan, err := GetScanByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var an Annotation
+	if err := decodeJSONBody(w, r, &an); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := an.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	an.ID = primitive.NewObjectID()
+	an.URL = scan.URL
+	an.ScanID = &scan.ID
+	an.CreatedAt = time.Now()
+	if err := an.Insert(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&an)
+}
+
+// acknowledgeGroupAudit annotates an audit on every URL of a site group at
+// once, e.g. to waive a known issue until it expires.
+func (a *App) acknowledgeGroupAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, err := GetGroupByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var ack Annotation
+	if err := decodeJSONBody(w, r, &ack); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := ack.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	annotations := []Annotation{}
+	for _, url := range group.URLs {
+		an := Annotation{
+			ID:        primitive.NewObjectID(),
+			URL:       url,
+			GroupID:   &group.ID,
+			Audit:     ack.Audit,
+			Note:      ack.Note,
+			CreatedAt: time.Now(),
+			ExpiresAt: ack.ExpiresAt,
+		}
+		if err := an.Insert(); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		annotations = append(annotations, an)
+	}
+	json.NewEncoder(w).Encode(&annotations)
+}
+
+func (a *App) deleteAnnotation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	an, err := GetAnnotationByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err := an.Delete(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&Annotation{})
+}
@@ -1,19 +1,25 @@
 package api
 
 import (
-	"bytes"
 	"cloud.google.com/go/storage"
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/rs/xid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -24,71 +30,366 @@ var (
 
 type App struct {
 	Router *mux.Router
+	// AdminToken is the bearer token required by the /admin endpoints.
+	AdminToken string
+	Workers    *WorkerPool
+	// RateLimiter limits the requests of every client, nil when disabled.
+	RateLimiter *RateLimiter
 }
 
 // "mongodb://localhost:27017"
 func NewApp() *App {
 	a := new(App)
+	a.AdminToken = os.Getenv("ADMIN_TOKEN")
+	a.Workers = CreateWorkerPool()
+	a.RateLimiter = CreateRateLimiter()
 	a.SetupRoutes()
-	CreateGCSClient()
+	CreateReportStore()
+	CreateChromePool()
+	checkDefaultRunner()
 	return a
 }
 
+// StartBackgroundTasks starts applying the retention policy and monitoring
+// the SLO. Both read the stores, so it must only be called once they are
+// connected.
+func (a *App) StartBackgroundTasks() {
+	if retention := CreateRetention(); retention != nil {
+		go retention.Run(time.Hour)
+	}
+	go CreateSLO().Monitor(time.Minute)
+}
+
 func (a *App) SetupRoutes() {
 	a.Router = mux.NewRouter()
+	if a.RateLimiter != nil {
+		a.Router.Use(a.RateLimiter.Middleware)
+	}
+	a.Router.HandleFunc("/ws", a.getScanFeedSocket).Methods("GET")
+	a.Router.HandleFunc("/healthz", a.getHealthz).Methods("GET")
+	a.Router.HandleFunc("/readyz", a.getReadyz).Methods("GET")
+	a.Router.HandleFunc("/stats", a.getStats).Methods("GET")
+	a.Router.HandleFunc("/metrics", a.getMetrics).Methods("GET")
 	a.Router.HandleFunc("/scans", a.getScans).Methods("GET")
 	a.Router.HandleFunc("/scans", a.createScan).Methods("POST")
+	a.Router.HandleFunc("/scans/validate", a.validateScan).Methods("POST")
+	a.Router.HandleFunc("/scans/compare", a.compareScansHandler).Methods("GET")
+	a.Router.HandleFunc("/scans/visual-diff", a.getVisualDiff).Methods("GET")
 	a.Router.HandleFunc("/scans/{id}", a.getScan).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}", a.patchScan).Methods("PATCH")
 	a.Router.HandleFunc("/scans/{id}", a.deleteScan).Methods("DELETE")
+	a.Router.HandleFunc("/scans/{id}/events", a.getScanEvents).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}/report", a.getScanReportHTML).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}/report.json", a.getScanReportJSON).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}/screenshot", a.getScanScreenshot).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}/resource-budget", a.getResourceBudget).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}/annotations", a.getScanAnnotations).Methods("GET")
+	a.Router.HandleFunc("/scans/{id}/annotations", a.createScanAnnotation).Methods("POST")
+	a.Router.HandleFunc("/annotations/{id}", a.deleteAnnotation).Methods("DELETE")
+	a.Router.HandleFunc("/crawls", a.createCrawl).Methods("POST")
+	a.Router.HandleFunc("/crawls/{id}", a.getCrawl).Methods("GET")
+	a.Router.HandleFunc("/jobs/{id}", a.getJob).Methods("GET")
+	a.Router.HandleFunc("/jobs/{id}", a.cancelJob).Methods("DELETE")
+	a.Router.HandleFunc("/groups", a.getGroups).Methods("GET")
+	a.Router.HandleFunc("/groups", a.createGroup).Methods("POST")
+	a.Router.HandleFunc("/groups/{id}", a.getGroup).Methods("GET")
+	a.Router.HandleFunc("/groups/{id}", a.updateGroup).Methods("PUT")
+	a.Router.HandleFunc("/groups/{id}", a.deleteGroup).Methods("DELETE")
+	a.Router.HandleFunc("/groups/{id}/score", a.getGroupScore).Methods("GET")
+	a.Router.HandleFunc("/groups/{id}/acknowledgments", a.acknowledgeGroupAudit).Methods("POST")
+	a.Router.HandleFunc("/groups/{id}/accessibility-issues", a.getGroupAccessibilityIssues).Methods("GET")
+	a.Router.HandleFunc("/projects", a.getProjects).Methods("GET")
+	a.Router.HandleFunc("/projects", a.createProject).Methods("POST")
+	a.Router.HandleFunc("/projects/{id}", a.getProject).Methods("GET")
+	a.Router.HandleFunc("/projects/{id}", a.updateProject).Methods("PUT")
+	a.Router.HandleFunc("/projects/{id}", a.deleteProject).Methods("DELETE")
+	a.Router.HandleFunc("/webhooks", a.getWebhooks).Methods("GET")
+	a.Router.HandleFunc("/webhooks", a.createWebhook).Methods("POST")
+	a.Router.HandleFunc("/webhooks/{id}", a.getWebhook).Methods("GET")
+	a.Router.HandleFunc("/webhooks/{id}", a.updateWebhook).Methods("PUT")
+	a.Router.HandleFunc("/webhooks/{id}", a.deleteWebhook).Methods("DELETE")
+	a.Router.HandleFunc("/metric-definitions", a.getMetricDefinitions).Methods("GET")
+	a.Router.HandleFunc("/metric-definitions", a.createMetricDefinition).Methods("POST")
+	a.Router.HandleFunc("/metric-definitions/{id}", a.getMetricDefinition).Methods("GET")
+	a.Router.HandleFunc("/metric-definitions/{id}", a.deleteMetricDefinition).Methods("DELETE")
+	a.Router.HandleFunc("/feeds/scans.atom", a.getScansFeed).Methods("GET")
+	a.Router.HandleFunc("/compare/matrix", a.getCompareMatrix).Methods("GET")
+	a.Router.HandleFunc("/forecast", a.getForecast).Methods("GET")
+	a.Router.HandleFunc("/reports/leaderboard", a.getLeaderboard).Methods("GET")
+	a.Router.HandleFunc("/reports/runner-variance", a.getRunnerVariance).Methods("GET")
+	a.Router.HandleFunc("/audits/flakiness", a.getAuditFlakiness).Methods("GET")
+	a.Router.HandleFunc("/user-timings/trend", a.getUserTimingTrend).Methods("GET")
+	a.Router.HandleFunc("/third-parties/trend", a.getThirdPartyTrend).Methods("GET")
+	a.Router.HandleFunc("/bundles/trend", a.getBundleTrend).Methods("GET")
+	a.Router.HandleFunc("/pwa/trend", a.getPWATrend).Methods("GET")
+	a.Router.HandleFunc("/admin/log-levels", a.requireAdmin(a.getLogLevels)).Methods("GET")
+	a.Router.HandleFunc("/admin/log-levels", a.requireAdmin(a.setLogLevel)).Methods("PUT")
+	a.Router.HandleFunc("/admin/query", a.requireAdmin(a.queryScans)).Methods("POST")
+	a.Router.HandleFunc("/admin/integrity-checks", a.requireAdmin(a.createIntegrityCheck)).Methods("POST")
+	a.Router.HandleFunc("/admin/integrity-checks/{id}", a.requireAdmin(a.getIntegrityCheck)).Methods("GET")
+	a.Router.HandleFunc("/admin/recoveries", a.requireAdmin(a.getRecoveries)).Methods("GET")
 }
 
+// Run serves the API on address until SIGINT or SIGTERM is received and
+// then shuts down gracefully.
 func (a *App) Run(address string) {
-	log.Print("Listening on :8000")
-	handler := cors.Default().Handler(a.Router)
-	http.ListenAndServe(address, handler)
+	srv := &http.Server{Addr: address, Handler: cors.Default().Handler(a.Router)}
+	go func() {
+		httpLog.Infof("Listening on %s", address)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	httpLog.Infof("Received %v, shutting down", sig)
+	a.Shutdown(srv)
 }
 
+// Shutdown stops srv from accepting requests, drains the running scans and
+// closes the connections to Chrome and Mongo. It waits for at most
+// SHUTDOWN_TIMEOUT_SECONDS, 30 by default, before killing the remaining
+// Lighthouse processes.
+func (a *App) Shutdown(srv *http.Server) {
+	timeout, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"))
+	if err != nil || timeout <= 0 {
+		timeout = 30
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		httpLog.Errorf("Shutting down the HTTP server failed: %v", err)
+	}
+	a.Workers.Shutdown(ctx)
+	if chromePool != nil {
+		chromePool.Close()
+	}
+	disconnectCtx, cancelDisconnect := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDisconnect()
+	if err := DB.Disconnect(disconnectCtx); err != nil {
+		storeLog.Errorf("Disconnecting from Mongo failed: %v", err)
+	}
+}
+
+// ScanList is a page of scans as returned by GET /scans.
+type ScanList struct {
+	Scans []Scan `json:"scans"`
+	Total int64  `json:"total"`
+	Page  int    `json:"page"`
+	Limit int    `json:"limit"`
+}
+
+var scanSortFields = map[string]bool{"created_at": true, "url": true}
+
 func (a *App) getScans(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	scans, err := GetAllScans()
+	page, limit, err := parsePagination(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	json.NewEncoder(w).Encode(&scans)
+	sort, err := parseSort(r, "-created_at", scanSortFields)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fields, projection, err := parseFields(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q, err := parseScanQuery(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Sort, q.Skip, q.Limit, q.Projection = sort, int64((page-1)*limit), int64(limit), projection
+	scans, err := Scans.List(q)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	total, err := Scans.Count(q)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	list := ScanList{Scans: scans, Total: total, Page: page, Limit: limit}
+	if fields == nil {
+		json.NewEncoder(w).Encode(&list)
+		return
+	}
+	partial, err := selectFields(scans, fields)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(&struct {
+		ScanList
+		Scans []map[string]interface{} `json:"scans"`
+	}{list, partial})
 }
 
 func (a *App) createScan(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	var scan Scan
-	err := decodeJSONBody(w, r, &scan)
-	if err != nil {
-		var mr *malformedRequest
-		if errors.As(err, &mr) {
-			http.Error(w, mr.msg, mr.status)
-		} else {
-			log.Println(err.Error())
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		}
+	var request Scan
+	if err := decodeJSONBody(w, r, &request); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	scan := request.requested()
+	if err := scan.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// Unless forced, a recent scan of the URL is returned instead of
+	// scanning it again, see SCAN_DEDUP_WINDOW.
+	if r.URL.Query().Get("force") != "true" {
+		cached, err := cachedJob(&scan)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		if cached != nil {
+			w.Header().Set("Location", "/scans/"+cached.ScanID.Hex())
+			json.NewEncoder(w).Encode(cached)
+			return
+		}
+	}
 	scan.ID = primitive.NewObjectID()
 	scan.CreatedAt = time.Now()
-	log.Printf("Decoded json from HTTP body. Scan: %+v", scan)
+	httpLog.Debugf("Decoded json from HTTP body. Scan: %+v", scan)
+	if err := scan.sealTargetAuth(); err != nil {
+		writeError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	scan.Status = ScanPending
+	if err := scan.Insert(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	job, err := a.Workers.Enqueue(&scan)
+	if err == ErrQueueFull || err == ErrShuttingDown {
+		writeError(w, r, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID.Hex())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// executeScan runs the scan runner of a pending scan and records the result,
+// or the reason it failed, on the stored scan. Scans running longer than
+// their timeout are killed.
+func executeScan(ctx context.Context, scan *Scan) error {
+	if err := scan.setStatus(ScanRunning, ""); err != nil {
+		return err
+	}
+	scan.RunnerType = scan.runnerType()
+	if scan.RunnerType == RunnerLighthouse {
+		scan.Runner = currentRunner()
+		scan.calibrateThrottling()
+	}
+	timeout := scan.timeout()
+	scanCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	scanErr := runScan(scanCtx, scan)
+	scan.Status = ScanSucceeded
+	if interrupted(ctx) {
+		scan.Status, scan.Error = ScanFailed, errInterrupted
+	} else if ctx.Err() == context.Canceled {
+		scan.Status, scan.Error = ScanCancelled, errCancelled
+	} else if scanCtx.Err() == context.DeadlineExceeded {
+		scanErr = fmt.Errorf("Scan timed out after %v", timeout)
+		scan.Status, scan.Error = ScanTimedOut, scanErr.Error()
+	} else if scanErr != nil {
+		scan.Status, scan.Error = ScanFailed, scanErr.Error()
+	}
+	if err := scan.Update(); err != nil {
+		return err
+	}
+	scanEvents.publishStatus(scan)
+	return scanErr
+}
+
+func runScan(ctx context.Context, scan *Scan) error {
+	var jsonResult, html []byte
+	runner, err := scan.scanRunner()
+	if err == nil {
+		err = verifyScanTarget(scan.URL, scan.HostOverrides)
+	}
+	if err == nil {
+		jsonResult, html, err = runner.Run(ctx, scan)
+	}
+	if scan.TargetAuth != nil {
+		scan.TargetAuth.Password = ""
+	}
+	if err != nil {
+		return err
+	}
+	if scan.JsonLocation, scan.HtmlLocation, err = storeReports(jsonResult, html); err != nil {
+		return err
+	}
+	report, err := ParseLighthouseReport(jsonResult)
+	if err != nil {
+		return err
+	}
+	// Remote runners measure their own machines, which says nothing about
+	// the runner the calibration is for.
+	if index := report.Environment.BenchmarkIndex; index != nil && scan.Runner != nil {
+		scan.Runner.BenchmarkIndex = index
+		calibration.observe(*index)
+	}
+	if scan.CustomMetrics, err = customMetricsFromReport(jsonResult); err != nil {
+		return err
+	}
+	if err := scan.storeScreenshot(report); err != nil {
+		return err
+	}
+	return scan.applyReport(report)
+}
+
+func (a *App) getScanReportHTML(w http.ResponseWriter, r *http.Request) {
+	a.serveReport(w, r, "text/html; charset=utf-8", func(scan *Scan) string { return scan.HtmlLocation })
+}
+
+func (a *App) getScanReportJSON(w http.ResponseWriter, r *http.Request) {
+	a.serveReport(w, r, "application/json", func(scan *Scan) string { return scan.JsonLocation })
+}
+
+func (a *App) getScanScreenshot(w http.ResponseWriter, r *http.Request) {
+	a.serveReport(w, r, "image/jpeg", func(scan *Scan) string { return scan.ScreenshotLocation })
+}
 
-	jsonLocation, jsonResult, err := runLightHouse(scan.URL)
+// serveReport streams the stored report of the scan at the location picked
+// by location.
+func (a *App) serveReport(w http.ResponseWriter, r *http.Request, contentType string, location func(*Scan) string) {
+	scan, err := GetScanByObjectIDHex(mux.Vars(r)["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeStoreError(w, r, err)
 		return
 	}
-	scan.JsonLocation = jsonLocation
-	scan.Json = string(jsonResult)
-	if err := scan.Insert(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if location(&scan) == "" {
+		writeError(w, r, "The report of scan "+scan.ID.Hex()+" is not available", http.StatusNotFound)
 		return
 	}
-	json.NewEncoder(w).Encode(&scan)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	report, err := openReport(ctx, location(&scan))
+	if err != nil {
+		writeReportError(w, r, &scan, err)
+		return
+	}
+	defer report.Close()
+	w.Header().Set("Content-Type", contentType)
+	if _, err := io.Copy(w, report); err != nil {
+		httpLog.Warnf("Streaming report of scan %s failed: %v", scan.ID.Hex(), err)
+	}
 }
 
 func (a *App) getScan(w http.ResponseWriter, r *http.Request) {
@@ -96,9 +397,42 @@ func (a *App) getScan(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	scan, err := GetScanByObjectIDHex(params["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeStoreError(w, r, err)
 		return
 	}
+	if include := r.URL.Query().Get("include"); include != "" {
+		for _, field := range strings.Split(include, ",") {
+			switch field {
+			case "report_json":
+				if scan.JsonLocation == "" {
+					writeError(w, r, "The report of scan "+scan.ID.Hex()+" is no longer available", http.StatusNotFound)
+					return
+				}
+				report, err := readReport(scan.JsonLocation)
+				if err != nil {
+					writeReportError(w, r, &scan, err)
+					return
+				}
+				scan.Json = string(report)
+			case "report_html":
+				// Scans of remote runners and scans whose report was purged
+				// have no HTML report.
+				if scan.HtmlLocation == "" {
+					writeError(w, r, "The HTML report of scan "+scan.ID.Hex()+" is not available", http.StatusNotFound)
+					return
+				}
+				report, err := readReport(scan.HtmlLocation)
+				if err != nil {
+					writeReportError(w, r, &scan, err)
+					return
+				}
+				scan.Html = string(report)
+			default:
+				writeError(w, r, "Unknown include "+field+", expected report_json or report_html", http.StatusBadRequest)
+				return
+			}
+		}
+	}
 	json.NewEncoder(w).Encode(&scan)
 }
 
@@ -107,16 +441,110 @@ func (a *App) deleteScan(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	scan, err := GetScanByObjectIDHex(params["id"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeStoreError(w, r, err)
 		return
 	}
 	if err := scan.Delete(); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeStoreError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(&Scan{})
 }
 
+// CompareMatrix holds the latest value of each metric for each URL. Values
+// has a row per URL and a column per metric, with null for URLs that were
+// never scanned or metrics that could not be scored.
+type CompareMatrix struct {
+	URLs    []string     `json:"urls"`
+	Metrics []string     `json:"metrics"`
+	Values  [][]*float64 `json:"values"`
+}
+
+func (a *App) getCompareMatrix(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	if query.Get("urls") == "" {
+		writeError(w, r, "Query parameter urls is required", http.StatusBadRequest)
+		return
+	}
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = "performance"
+	}
+	matrix := CompareMatrix{
+		URLs:    strings.Split(query.Get("urls"), ","),
+		Metrics: strings.Split(metric, ","),
+	}
+	for _, url := range matrix.URLs {
+		_, scores, err := latestScores(url, matrix.Metrics)
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		matrix.Values = append(matrix.Values, scores)
+	}
+	json.NewEncoder(w).Encode(&matrix)
+}
+
+// latestScores returns the most recent scan of url along with the given
+// values of it, see Scan.Value. The scan is nil and all values are nil
+// when the URL was never scanned.
+func latestScores(url string, names []string) (*Scan, []*float64, error) {
+	values := make([]*float64, len(names))
+	scan, err := GetLatestScanByURL(url)
+	if err == mongo.ErrNoDocuments {
+		return nil, values, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if scan.Scores == nil && scan.JsonLocation != "" {
+		// Scans stored before scores were parsed on ingest.
+		data, err := readReport(scan.JsonLocation)
+		if err != nil {
+			return nil, nil, err
+		}
+		report, err := ParseLighthouseReport(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		scan.Scores = scoresFromReport(report)
+		scan.Metrics = metricsFromReport(report)
+	}
+	for i, name := range names {
+		values[i] = scan.Value(name)
+	}
+	return &scan, values, nil
+}
+
+type logLevelRequest struct {
+	Component string `json:"component"`
+	Level     string `json:"level"`
+}
+
+func (a *App) getLogLevels(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetLogLevels())
+}
+
+func (a *App) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var req logLevelRequest
+	if err := decodeJSONBody(w, r, &req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	level, err := ParseLogLevel(req.Level)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := SetLogLevel(req.Component, level); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(GetLogLevels())
+}
+
 func CreateGCSClient() *storage.Client {
 	ctx := context.Background()
 	Bucket = os.Getenv("GCS_BUCKET")
@@ -129,29 +557,106 @@ func CreateGCSClient() *storage.Client {
 	return gcsClient
 }
 
-func runLightHouse(url string) (objectID string, json []byte, err error) {
-	// lighthouse --chrome-flags="--headless" $URL --output="html" --output=json --output-path=/tmp/$URL
+// maxErrorOutput is how much of the Lighthouse stderr output is kept on a
+// failed scan.
+const maxErrorOutput = 4096
+
+// tail returns the last n bytes of s.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+// reportsBaseDir returns the directory in which each scan gets its own
+// output directory, configured with REPORTS_DIR.
+func reportsBaseDir() string {
+	if dir := os.Getenv("REPORTS_DIR"); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+// lighthouseArgs returns the arguments passed to the lighthouse binary to
+// run scan, writing the report into outputDir. When port is not 0
+// Lighthouse connects to the already running Chrome listening on it
+// instead of launching its own.
+func lighthouseArgs(scan *Scan, port int, outputDir string) []string {
+	args := []string{}
+	if port != 0 {
+		args = append(args, "--port="+strconv.Itoa(port))
+	} else {
+		flags := []string{"--headless"}
+		if scan.ChromeProfile == ChromeProfilePersistent {
+			flags = append(flags, "--user-data-dir="+persistentProfileDir(scan.URL))
+		}
+		for _, preset := range scan.ProtocolPresets {
+			flags = append(flags, protocolPresets[preset]...)
+		}
+		if rules := hostResolverRulesFlag(scan.HostOverrides); rules != "" {
+			flags = append(flags, rules)
+		}
+		if scan.Options != nil {
+			flags = append(flags, scan.Options.ChromeFlags...)
+		}
+		args = append(args, "--chrome-flags=\""+strings.Join(flags, " ")+"\"")
+	}
+	if scan.ChromeProfile == ChromeProfilePersistent {
+		args = append(args, "--disable-storage-reset")
+	}
+	args = append(args, scan.Options.args()...)
+	if scan.CPUSlowdown != nil {
+		args = append(args, "--throttling.cpuSlowdownMultiplier="+
+			strconv.FormatFloat(*scan.CPUSlowdown, 'f', -1, 64))
+	}
+	// With more than one output Lighthouse appends .report.json and
+	// .report.html to the output path.
+	return append(args, scan.targetURL(), "--output=json", "--output=html",
+		"--output-path="+filepath.Join(outputDir, "report"))
+}
+
+// storeReports stores the JSON result and, when there is one, the HTML
+// report of a run in Reports and returns their locations.
+func storeReports(result []byte, html []byte) (jsonLocation string, htmlLocation string, err error) {
 	guid := xid.New().String()
-	objectID = guid + ".json"
-	outputGCS := gcsClient.Bucket(Bucket).Object(objectID)
-	ctx := context.Background()
-	w := outputGCS.NewWriter(ctx)
-	defer w.Close()
-	cmd := exec.Command("lighthouse", "--chrome-flags=\"--headless\"", url,
-		"--output=json", "--output-path=stdout")
-	var stdErr bytes.Buffer
-	var stdOut bytes.Buffer
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-	log.Printf("Running command %+v", cmd)
-	if err = cmd.Run(); err != nil {
-		log.Print(err)
-		return "", nil, err
-	}
-	result := stdOut.Bytes()
-	if _, err := w.Write(result); err != nil {
-		log.Print(err)
-		return "", nil, err
-	}
-	return "gs://" + Bucket + "/" + objectID, result, nil
+	if jsonLocation, err = writeReport(guid+".json", result); err != nil {
+		return "", "", err
+	}
+	if html != nil {
+		if htmlLocation, err = writeReport(guid+".html", html); err != nil {
+			return "", "", err
+		}
+	}
+	return jsonLocation, htmlLocation, nil
+}
+
+// writeReport stores data as the report name and returns its location.
+func writeReport(name string, data []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	location, err := Reports.Put(ctx, name, data)
+	if err != nil {
+		storeLog.Errorf("Writing report %s failed: %v", name, err)
+	}
+	return location, err
+}
+
+func deleteReport(ctx context.Context, location string) error {
+	return Reports.Delete(ctx, location)
+}
+
+func openReport(ctx context.Context, location string) (io.ReadCloser, error) {
+	return Reports.Open(ctx, location)
+}
+
+func readReport(location string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	r, err := openReport(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
 }
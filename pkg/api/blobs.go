@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// Reports stores the Lighthouse reports of scans, which are too large to
+// keep in the scans themselves. Scans only keep the location returned by
+// Put.
+var Reports BlobStore
+
+var ErrReportNotFound = errors.New("Report does not exist")
+
+// BlobStore stores named blobs. Open, Delete and Exists take the location
+// returned by Put and return ErrReportNotFound for missing blobs.
+type BlobStore interface {
+	Put(ctx context.Context, name string, data []byte) (location string, err error)
+	Open(ctx context.Context, location string) (io.ReadCloser, error)
+	Delete(ctx context.Context, location string) error
+	Exists(ctx context.Context, location string) (bool, error)
+}
+
+// CreateReportStore sets up the store selected with REPORT_STORE: gcs, the
+// default, writes to the GCS_BUCKET bucket and local to the
+// REPORTS_STORAGE_DIR directory. Reports written to one store can't be read
+// after switching to the other.
+func CreateReportStore() BlobStore {
+	switch backend := os.Getenv("REPORT_STORE"); backend {
+	case "", "gcs":
+		Reports = &gcsBlobStore{client: CreateGCSClient(), bucket: Bucket}
+	case "local":
+		dir := os.Getenv("REPORTS_STORAGE_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "websu-reports")
+		}
+		Reports = &localBlobStore{dir: dir}
+	default:
+		log.Fatalf("Unknown REPORT_STORE %s, expected gcs or local", backend)
+	}
+	return Reports
+}
+
+// gcsBlobStore keeps blobs as objects of a GCS bucket.
+type gcsBlobStore struct {
+	client *storage.Client
+	bucket string
+}
+
+func (s *gcsBlobStore) object(location string) *storage.ObjectHandle {
+	return s.client.Bucket(s.bucket).Object(filepath.Base(location))
+}
+
+func (s *gcsBlobStore) Put(ctx context.Context, name string, data []byte) (string, error) {
+	w := s.client.Bucket(s.bucket).Object(name).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return "gs://" + s.bucket + "/" + name, nil
+}
+
+func (s *gcsBlobStore) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	r, err := s.object(location).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, ErrReportNotFound
+	}
+	return r, err
+}
+
+func (s *gcsBlobStore) Delete(ctx context.Context, location string) error {
+	err := s.object(location).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return ErrReportNotFound
+	}
+	return err
+}
+
+func (s *gcsBlobStore) Exists(ctx context.Context, location string) (bool, error) {
+	_, err := s.object(location).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// localBlobStore keeps blobs as files in a directory, for deployments
+// without GCS.
+type localBlobStore struct {
+	dir string
+}
+
+// path returns the file of location. Only the base name of location is
+// used so locations can't point outside of the directory.
+func (s *localBlobStore) path(location string) string {
+	return filepath.Join(s.dir, filepath.Base(strings.TrimPrefix(location, "file://")))
+}
+
+func (s *localBlobStore) Put(ctx context.Context, name string, data []byte) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+	path := s.path(name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return "file://" + path, nil
+}
+
+func (s *localBlobStore) Open(ctx context.Context, location string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(location))
+	if os.IsNotExist(err) {
+		return nil, ErrReportNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, location string) error {
+	err := os.Remove(s.path(location))
+	if os.IsNotExist(err) {
+		return ErrReportNotFound
+	}
+	return err
+}
+
+func (s *localBlobStore) Exists(ctx context.Context, location string) (bool, error) {
+	_, err := os.Stat(s.path(location))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
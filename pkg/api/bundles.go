@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultBundleDays = 30
+
+// BundleUsage is how much of a script or stylesheet a scan didn't use.
+// Bundle is the file name with content hashes stripped, so the same bundle
+// can be followed across deployments.
+type BundleUsage struct {
+	Bundle      string  `json:"bundle" bson:"bundle"`
+	Type        string  `json:"type" bson:"type"`
+	URL         string  `json:"url" bson:"url"`
+	TotalBytes  float64 `json:"total_bytes" bson:"total_bytes"`
+	UnusedBytes float64 `json:"unused_bytes" bson:"unused_bytes"`
+}
+
+// BundleTrend is the series of the unused bytes of a bundle on a URL, one
+// point per scan that loaded it, oldest first.
+type BundleTrend struct {
+	URL    string        `json:"url"`
+	Bundle string        `json:"bundle"`
+	Days   int           `json:"days"`
+	Points []BundlePoint `json:"points"`
+}
+
+type BundlePoint struct {
+	ScanID      primitive.ObjectID `json:"scan_id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	TotalBytes  float64            `json:"total_bytes"`
+	UnusedBytes float64            `json:"unused_bytes"`
+}
+
+var hashPartPattern = regexp.MustCompile(`[.\-_~][A-Za-z0-9]+`)
+
+// bundleName returns the file name of rawURL without content hashes, e.g.
+// main.js for https://cdn.example.com/static/main.3f2a1b9c.js.
+func bundleName(rawURL string) string {
+	name := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Path != "" {
+		name = u.Path
+	}
+	name = path.Base(name)
+	return hashPartPattern.ReplaceAllStringFunc(name, func(part string) string {
+		if isHash(part[1:]) {
+			return ""
+		}
+		return part
+	})
+}
+
+// isHash reports whether s looks like a content hash: hex of at least 6
+// characters or at least 8 characters mixing letters and digits.
+func isHash(s string) bool {
+	letters, digits, hex := false, false, true
+	for _, r := range s {
+		switch {
+		case unicode.IsDigit(r):
+			digits = true
+		case unicode.IsLetter(r):
+			letters = true
+			if !strings.ContainsRune("abcdefABCDEF", r) {
+				hex = false
+			}
+		}
+	}
+	return digits && ((hex && len(s) >= 6) || (letters && len(s) >= 8))
+}
+
+// BundleUsages returns the unused bytes of the scripts and stylesheets of
+// the unused-javascript and unused-css-rules audits.
+func (r *LighthouseReport) BundleUsages() ([]BundleUsage, error) {
+	usages := []BundleUsage{}
+	for _, audit := range []struct{ id, kind string }{
+		{"unused-javascript", "js"},
+		{"unused-css-rules", "css"},
+	} {
+		var items []struct {
+			URL         string  `json:"url"`
+			TotalBytes  float64 `json:"totalBytes"`
+			WastedBytes float64 `json:"wastedBytes"`
+		}
+		if err := r.auditItems(audit.id, &items); err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			usages = append(usages, BundleUsage{
+				Bundle:      bundleName(item.URL),
+				Type:        audit.kind,
+				URL:         item.URL,
+				TotalBytes:  item.TotalBytes,
+				UnusedBytes: item.WastedBytes,
+			})
+		}
+	}
+	return usages, nil
+}
+
+// getBundleTrend returns the trend of the unused bytes of ?bundle on ?url
+// over the last ?days days, 30 by default. Bundles loaded from several
+// URLs in one scan are summed up.
+func (a *App) getBundleTrend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	days, err := parseTrendQuery(r, defaultBundleDays, "url", "bundle")
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	trend := BundleTrend{URL: query.Get("url"), Bundle: query.Get("bundle"), Days: days}
+	filter := bson.M{"url": trend.URL, "unused_code.bundle": trend.Bundle}
+	scans, err := findTrendScans(QueryTrends, filter, days, "unused_code")
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	trend.Points = []BundlePoint{}
+	for _, scan := range scans {
+		point := BundlePoint{ScanID: scan.ID, CreatedAt: scan.CreatedAt}
+		for _, usage := range scan.UnusedCode {
+			if usage.Bundle == trend.Bundle {
+				point.TotalBytes += usage.TotalBytes
+				point.UnusedBytes += usage.UnusedBytes
+			}
+		}
+		trend.Points = append(trend.Points, point)
+	}
+	json.NewEncoder(w).Encode(&trend)
+}
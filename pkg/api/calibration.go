@@ -0,0 +1,69 @@
+package api
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// referenceBenchmarkIndex is the benchmark index of the hardware the
+	// default mobile CPU throttling of Lighthouse is calibrated for.
+	referenceBenchmarkIndex = 1300
+	defaultCPUSlowdown      = 4
+	maxCPUSlowdown          = 10
+)
+
+// calibration tracks the benchmark index Lighthouse measured on this runner
+// to adjust the CPU throttling of scans when THROTTLING_CALIBRATION is true,
+// so that scores of slow and fast runners are comparable.
+var calibration = &cpuCalibration{}
+
+type cpuCalibration struct {
+	mu             sync.Mutex
+	benchmarkIndex float64
+}
+
+// observe adds the benchmark index measured by a scan to the moving
+// average of the runner.
+func (c *cpuCalibration) observe(index float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.benchmarkIndex == 0 {
+		c.benchmarkIndex = index
+	} else {
+		c.benchmarkIndex = 0.7*c.benchmarkIndex + 0.3*index
+	}
+}
+
+// slowdown returns the CPU slowdown multiplier that makes the runner as
+// fast as the reference hardware throttled by the default multiplier, or
+// nil until a scan measured the runner.
+func (c *cpuCalibration) slowdown() *float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.benchmarkIndex == 0 {
+		return nil
+	}
+	m := defaultCPUSlowdown * c.benchmarkIndex / referenceBenchmarkIndex
+	m = math.Round(math.Max(1, math.Min(maxCPUSlowdown, m))*10) / 10
+	return &m
+}
+
+func calibrationEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("THROTTLING_CALIBRATION"))
+	return enabled
+}
+
+// calibrateThrottling sets the CPU slowdown of scan from the calibration.
+// Desktop scans and scans without throttling are left alone.
+func (scan *Scan) calibrateThrottling() {
+	if !calibrationEnabled() {
+		return
+	}
+	if o := scan.Options; o != nil && (o.FormFactor == FormFactorDesktop || o.Throttling == "provided") {
+		return
+	}
+	scan.CPUSlowdown = calibration.slowdown()
+}
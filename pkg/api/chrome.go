@@ -0,0 +1,231 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var chromePool *ChromePool
+
+const (
+	// ChromeProfileFresh launches a new Chrome with an empty profile for
+	// the scan, for clean-room results.
+	ChromeProfileFresh = "fresh"
+	// ChromeProfilePersistent reuses a Chrome profile per domain and keeps
+	// its storage between scans, so logged-in sessions survive.
+	ChromeProfilePersistent = "persistent"
+)
+
+// protocolPresets maps the protocol presets a scan can enable to the Chrome
+// flags implementing them, to measure the impact of protocols on a site.
+var protocolPresets = map[string][]string{
+	"http3":    {"--enable-quic"},
+	"no-http3": {"--disable-quic"},
+	"no-http2": {"--disable-http2"},
+}
+
+var profileLocks = struct {
+	sync.Mutex
+	m map[string]*sync.Mutex
+}{m: make(map[string]*sync.Mutex)}
+
+// usesChromePool reports whether scan runs in a pooled Chrome instance.
+// Scans that ask for a specific profile policy always get their own Chrome.
+// So do scans with target credentials: Chrome caches them per origin for
+// its lifetime and would send them along in later scans of the origin.
+func (scan *Scan) usesChromePool() bool {
+	return chromePool != nil && scan.ChromeProfile == "" && len(scan.ProtocolPresets) == 0 &&
+		len(scan.HostOverrides) == 0 && (scan.Options == nil || len(scan.Options.ChromeFlags) == 0) &&
+		scan.TargetAuth == nil && scan.EncryptedTargetAuth == ""
+}
+
+var hostPattern = regexp.MustCompile(`^[A-Za-z0-9*.-]+$`)
+
+func validateHostOverrides(overrides map[string]string) error {
+	for host, ip := range overrides {
+		if !hostPattern.MatchString(host) {
+			return errors.New("Host override " + host + " is not a valid host name")
+		}
+		if net.ParseIP(ip) == nil {
+			return errors.New("Host override " + host + " must map to an IP address, got " + ip)
+		}
+	}
+	return nil
+}
+
+// hostResolverRulesFlag returns the Chrome flag mapping the hosts of
+// overrides to their IP addresses, or "" when there are no overrides.
+func hostResolverRulesFlag(overrides map[string]string) string {
+	if len(overrides) == 0 {
+		return ""
+	}
+	hosts := make([]string, 0, len(overrides))
+	for host := range overrides {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	rules := make([]string, len(hosts))
+	for i, host := range hosts {
+		rules[i] = "MAP " + host + " " + overrides[host]
+	}
+	return "--host-resolver-rules='" + strings.Join(rules, ",") + "'"
+}
+
+func validateProtocolPresets(presets []string) error {
+	enabled := make(map[string]bool)
+	for _, preset := range presets {
+		if _, ok := protocolPresets[preset]; !ok {
+			return errors.New("Unknown protocol preset " + preset + ", expected http3, no-http3 or no-http2")
+		}
+		enabled[preset] = true
+	}
+	if enabled["http3"] && enabled["no-http3"] {
+		return errors.New("Protocol presets http3 and no-http3 can't be combined")
+	}
+	return nil
+}
+
+// persistentProfileDir returns the Chrome user data dir shared by all
+// persistent-profile scans of the host of rawURL. Profiles are kept in
+// CHROME_PROFILES_DIR.
+func persistentProfileDir(rawURL string) string {
+	base := os.Getenv("CHROME_PROFILES_DIR")
+	if base == "" {
+		base = filepath.Join(os.TempDir(), "websu-chrome-profiles")
+	}
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Hostname()
+	}
+	return filepath.Join(base, host)
+}
+
+// lockProfile serializes access to a Chrome profile directory. It returns
+// the function that releases the lock.
+func lockProfile(dir string) func() {
+	profileLocks.Lock()
+	l, ok := profileLocks.m[dir]
+	if !ok {
+		l = new(sync.Mutex)
+		profileLocks.m[dir] = l
+	}
+	profileLocks.Unlock()
+	l.Lock()
+	return l.Unlock
+}
+
+// ChromePool keeps a number of headless Chrome instances running so that
+// Lighthouse can connect to them with --port instead of launching a new
+// browser for every scan. An instance that exits is relaunched.
+type ChromePool struct {
+	chromePath string
+	basePort   int
+	ports      chan int
+	mu         sync.Mutex
+	cmds       map[int]*exec.Cmd
+	closed     bool
+}
+
+// CreateChromePool launches CHROME_POOL_SIZE Chrome instances listening on
+// consecutive debugging ports starting at CHROME_POOL_BASE_PORT. The pool
+// is disabled when CHROME_POOL_SIZE is unset or 0.
+func CreateChromePool() *ChromePool {
+	size, _ := strconv.Atoi(os.Getenv("CHROME_POOL_SIZE"))
+	if size <= 0 {
+		return nil
+	}
+	basePort, err := strconv.Atoi(os.Getenv("CHROME_POOL_BASE_PORT"))
+	if err != nil {
+		basePort = 9222
+	}
+	chromePath := os.Getenv("CHROME_PATH")
+	if chromePath == "" {
+		chromePath = "google-chrome"
+	}
+	chromePool = NewChromePool(chromePath, size, basePort)
+	return chromePool
+}
+
+func NewChromePool(chromePath string, size int, basePort int) *ChromePool {
+	p := &ChromePool{
+		chromePath: chromePath,
+		basePort:   basePort,
+		ports:      make(chan int, size),
+		cmds:       make(map[int]*exec.Cmd),
+	}
+	for port := basePort; port < basePort+size; port++ {
+		go p.keepRunning(port)
+		p.ports <- port
+	}
+	return p
+}
+
+func (p *ChromePool) keepRunning(port int) {
+	for {
+		userDataDir, err := ioutil.TempDir("", "chrome-pool-"+strconv.Itoa(port))
+		if err != nil {
+			engineLog.Errorf("Creating profile for Chrome on port %d failed: %v", port, err)
+			return
+		}
+		cmd := exec.Command(p.chromePath, "--headless", "--disable-gpu", "--no-first-run",
+			"--remote-debugging-port="+strconv.Itoa(port), "--user-data-dir="+userDataDir)
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			os.RemoveAll(userDataDir)
+			return
+		}
+		err = cmd.Start()
+		if err == nil {
+			p.cmds[port] = cmd
+		}
+		p.mu.Unlock()
+		if err != nil {
+			engineLog.Errorf("Launching Chrome on port %d failed: %v", port, err)
+		} else {
+			engineLog.Infof("Launched Chrome on port %d", port)
+			err = cmd.Wait()
+		}
+		os.RemoveAll(userDataDir)
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			return
+		}
+		engineLog.Warnf("Chrome on port %d exited: %v. Relaunching", port, err)
+		time.Sleep(time.Second)
+	}
+}
+
+// Acquire blocks until a Chrome instance is free and returns its debugging
+// port.
+func (p *ChromePool) Acquire() int {
+	return <-p.ports
+}
+
+// Release returns the instance listening on port to the pool.
+func (p *ChromePool) Release(port int) {
+	p.ports <- port
+}
+
+// Close stops all Chrome instances of the pool.
+func (p *ChromePool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, cmd := range p.cmds {
+		cmd.Process.Kill()
+	}
+}
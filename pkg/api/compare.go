@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// compareCategories and compareMetrics are the values diffed by
+// GET /scans/compare, in the order they are returned.
+var (
+	compareCategories = []string{"performance", "accessibility", "best-practices", "seo", "pwa"}
+	compareMetrics    = []string{"fcp", "lcp", "tbt", "cls", "si", "tti"}
+)
+
+// ScanComparison is the difference between a base and a head scan, e.g.
+// before and after a deployment. Audits that went from passing to failing
+// are listed as regressed and the other way around as improved, unless
+// they are annotated on the URL of the head scan, see Suppressed.
+type ScanComparison struct {
+	Base       primitive.ObjectID `json:"base"`
+	Head       primitive.ObjectID `json:"head"`
+	Categories []ValueDelta       `json:"categories"`
+	Metrics    []ValueDelta       `json:"metrics"`
+	Regressed  []string           `json:"regressed_audits"`
+	Improved   []string           `json:"improved_audits"`
+	Suppressed []string           `json:"suppressed_audits"`
+}
+
+// ValueDelta is the change of a score or metric. Delta is null when either
+// scan lacks the value.
+type ValueDelta struct {
+	Name  string   `json:"name"`
+	Base  *float64 `json:"base"`
+	Head  *float64 `json:"head"`
+	Delta *float64 `json:"delta"`
+}
+
+func valueDeltas(base *Scan, head *Scan, names []string) []ValueDelta {
+	deltas := []ValueDelta{}
+	for _, name := range names {
+		d := ValueDelta{Name: name, Base: base.Value(name), Head: head.Value(name)}
+		if d.Base != nil && d.Head != nil {
+			delta := *d.Head - *d.Base
+			d.Delta = &delta
+		}
+		deltas = append(deltas, d)
+	}
+	return deltas
+}
+
+// compareScans diffs base and head, leaving out the annotated audits.
+func compareScans(base *Scan, head *Scan, annotated map[string]bool) *ScanComparison {
+	c := &ScanComparison{
+		Base:       base.ID,
+		Head:       head.ID,
+		Categories: valueDeltas(base, head, compareCategories),
+		Metrics:    valueDeltas(base, head, compareMetrics),
+		Regressed:  []string{},
+		Improved:   []string{},
+		Suppressed: []string{},
+	}
+	for audit, passed := range head.AuditResults {
+		basePassed, ok := base.AuditResults[audit]
+		if !ok || basePassed == passed {
+			continue
+		}
+		if annotated[audit] {
+			c.Suppressed = append(c.Suppressed, audit)
+		} else if passed {
+			c.Improved = append(c.Improved, audit)
+		} else {
+			c.Regressed = append(c.Regressed, audit)
+		}
+	}
+	sort.Strings(c.Improved)
+	sort.Strings(c.Regressed)
+	sort.Strings(c.Suppressed)
+	return c
+}
+
+// compareScansHandler diffs the scans ?base and ?head.
+func (a *App) compareScansHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	if query.Get("base") == "" || query.Get("head") == "" {
+		writeError(w, r, "Query parameters base and head are required", http.StatusBadRequest)
+		return
+	}
+	base, err := GetScanByObjectIDHex(query.Get("base"))
+	if err != nil {
+		writeError(w, r, "Base scan: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	head, err := GetScanByObjectIDHex(query.Get("head"))
+	if err != nil {
+		writeError(w, r, "Head scan: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	annotated, err := annotatedAudits(head.URL)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(compareScans(&base, &head, annotated))
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/html"
+)
+
+const (
+	defaultCrawlMaxPages    = 100
+	maxCrawlPages           = 1000
+	maxCrawlDepth           = 5
+	defaultCrawlConcurrency = 1
+	// maxCrawlDocumentSize is the number of bytes of a sitemap or page read
+	// while discovering pages.
+	maxCrawlDocumentSize = 10 << 20
+)
+
+// crawlPollInterval is how often a crawl checks whether its scans are
+// done.
+var crawlPollInterval = 2 * time.Second
+
+// crawlClient applies the target checks to every address it connects to,
+// including those of redirects.
+var crawlClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: &http.Transport{DialContext: targetDialContext},
+}
+
+// Crawl scans the pages of a site. The pages are listed by the sitemap.xml
+// at Sitemap or, without a sitemap, discovered by following the links of
+// URL up to Depth links deep, staying on its host. At most Concurrency
+// scans of the crawl are queued at the same time so that a crawl doesn't
+// hold up other scans for long.
+//
+// A crawl that is interrupted by a restart stays running. Its queued scans
+// are recovered, the pages it had not queued yet are not scanned.
+type Crawl struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Sitemap     string             `json:"sitemap,omitempty" bson:"sitemap,omitempty"`
+	URL         string             `json:"url,omitempty" bson:"url,omitempty"`
+	Depth       int                `json:"depth" bson:"depth"`
+	MaxPages    int                `json:"max_pages" bson:"max_pages"`
+	Concurrency int                `json:"concurrency" bson:"concurrency"`
+	Status      string             `json:"status" bson:"status"`
+	Error       string             `json:"error,omitempty" bson:"error,omitempty"`
+	// Pages are the discovered pages. Jobs holds the job of each page that
+	// was queued, Skipped why the others were not.
+	Pages   []string             `json:"pages" bson:"pages"`
+	Jobs    []primitive.ObjectID `json:"jobs" bson:"jobs"`
+	Skipped []string             `json:"skipped,omitempty" bson:"skipped,omitempty"`
+	// Progress counts the jobs of the crawl by status.
+	Progress   map[string]int `json:"progress" bson:"-"`
+	CreatedAt  time.Time      `json:"created_at" bson:"created_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+func (c *Crawl) validate() error {
+	if (c.Sitemap == "") == (c.URL == "") {
+		return errors.New("Crawl needs either a sitemap or a url")
+	}
+	start := c.URL
+	if c.Sitemap != "" {
+		start = c.Sitemap
+	}
+	if err := validateScanURL(start); err != nil {
+		return err
+	}
+	if err := validateScanTarget(start, nil); err != nil {
+		return err
+	}
+	if c.Depth < 0 || c.Depth > maxCrawlDepth {
+		return fmt.Errorf("depth must be between 0 and %d", maxCrawlDepth)
+	}
+	if c.MaxPages < 0 || c.MaxPages > maxCrawlPages {
+		return fmt.Errorf("max_pages must be between 1 and %d", maxCrawlPages)
+	}
+	if c.Concurrency < 0 || c.Concurrency > scanQueueSize {
+		return fmt.Errorf("concurrency must be between 1 and %d", scanQueueSize)
+	}
+	return nil
+}
+
+// fetch GETs a sitemap or page of the crawl, refusing targets scans are
+// not allowed to reach.
+func fetch(rawURL string) (*http.Response, error) {
+	if err := validateScanTarget(rawURL, nil); err != nil {
+		return nil, err
+	}
+	resp, err := crawlClient.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("Fetching " + rawURL + " failed: " + resp.Status)
+	}
+	return resp, nil
+}
+
+// sitemap is a sitemap.xml, either a list of pages or an index of further
+// sitemaps.
+type sitemap struct {
+	Pages    []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+// sitemapPages returns the pages listed by the sitemap at rawURL and, for
+// a top-level sitemap, by the sitemaps it indexes. Sitemap indexes can't be
+// nested, so indexes found in indexed sitemaps are ignored.
+func (c *Crawl) sitemapPages(rawURL string, topLevel bool) ([]string, error) {
+	resp, err := fetch(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var s sitemap
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, maxCrawlDocumentSize)).Decode(&s); err != nil {
+		return nil, errors.New("Sitemap " + rawURL + " could not be parsed: " + err.Error())
+	}
+	pages := []string{}
+	for _, page := range s.Pages {
+		pages = append(pages, strings.TrimSpace(page))
+	}
+	for _, index := range s.Sitemaps {
+		if !topLevel || len(pages) >= c.MaxPages {
+			break
+		}
+		more, err := c.sitemapPages(strings.TrimSpace(index), false)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, more...)
+	}
+	return pages, nil
+}
+
+// links returns the absolute URLs of the links of the HTML page at rawURL,
+// without their fragment.
+func links(rawURL string) ([]string, error) {
+	resp, err := fetch(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, nil
+	}
+	base := resp.Request.URL
+	found := []string{}
+	z := html.NewTokenizer(io.LimitReader(resp.Body, maxCrawlDocumentSize))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return found, nil
+			}
+			return found, z.Err()
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = z.TagAttr()
+				if string(key) != "href" {
+					continue
+				}
+				if u, err := base.Parse(string(value)); err == nil {
+					u.Fragment = ""
+					found = append(found, u.String())
+				}
+			}
+		}
+	}
+}
+
+// discover returns the pages to scan, at most MaxPages.
+func (c *Crawl) discover() ([]string, error) {
+	var pages []string
+	if c.Sitemap != "" {
+		var err error
+		if pages, err = c.sitemapPages(c.Sitemap, true); err != nil {
+			return nil, err
+		}
+	} else {
+		root, err := url.Parse(c.URL)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{c.URL: true}
+		pages = []string{c.URL}
+		level := []string{c.URL}
+		for depth := 0; depth < c.Depth && len(level) > 0 && len(pages) < c.MaxPages; depth++ {
+			next := []string{}
+			for _, page := range level {
+				if len(pages) >= c.MaxPages {
+					break
+				}
+				found, err := links(page)
+				if err != nil {
+					engineLog.Warnf("Crawl %s could not follow the links of %s: %v", c.ID.Hex(), page, err)
+					continue
+				}
+				for _, link := range found {
+					u, err := url.Parse(link)
+					if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+						!strings.EqualFold(u.Hostname(), root.Hostname()) || seen[link] {
+						continue
+					}
+					seen[link] = true
+					pages = append(pages, link)
+					next = append(next, link)
+				}
+			}
+			level = next
+		}
+	}
+	if len(pages) > c.MaxPages {
+		pages = pages[:c.MaxPages]
+	}
+	return pages, nil
+}
+
+// run discovers the pages of the crawl and scans them through pool.
+func (c *Crawl) run(pool *WorkerPool) {
+	pages, err := c.discover()
+	if err != nil {
+		c.finish(err)
+		return
+	}
+	c.Pages = pages
+	if err := c.save(); err != nil {
+		storeLog.Errorf("Saving crawl %s failed: %v", c.ID.Hex(), err)
+	}
+	slots := make(chan struct{}, c.Concurrency)
+	for _, page := range pages {
+		slots <- struct{}{}
+		scan := &Scan{URL: page}
+		if err := scan.validate(); err != nil {
+			c.Skipped = append(c.Skipped, page+": "+err.Error())
+			<-slots
+			continue
+		}
+		scan.ID = primitive.NewObjectID()
+		scan.CreatedAt = time.Now()
+		scan.Status = ScanPending
+		if err := scan.Insert(); err != nil {
+			c.finish(err)
+			return
+		}
+		job, err := pool.Enqueue(scan)
+		if err == ErrQueueFull {
+			c.Skipped = append(c.Skipped, page+": "+err.Error())
+			<-slots
+			continue
+		} else if err != nil {
+			c.finish(err)
+			return
+		}
+		c.Jobs = append(c.Jobs, job.ID)
+		if err := c.save(); err != nil {
+			storeLog.Errorf("Saving crawl %s failed: %v", c.ID.Hex(), err)
+		}
+		go func(id primitive.ObjectID) {
+			awaitJob(id)
+			<-slots
+		}(job.ID)
+	}
+	for i := 0; i < c.Concurrency; i++ {
+		slots <- struct{}{}
+	}
+	c.finish(nil)
+}
+
+// awaitJob blocks until the job is no longer queued or running.
+func awaitJob(id primitive.ObjectID) {
+	for {
+		job, err := GetJobByObjectIDHex(id.Hex())
+		if err != nil {
+			storeLog.Errorf("Loading job %s failed: %v", id.Hex(), err)
+		} else if job.Status != JobQueued && job.Status != JobRunning {
+			return
+		}
+		time.Sleep(crawlPollInterval)
+	}
+}
+
+func (c *Crawl) finish(err error) {
+	c.Status = JobDone
+	if err != nil {
+		engineLog.Errorf("Crawl %s failed: %v", c.ID.Hex(), err)
+		c.Status, c.Error = JobFailed, err.Error()
+	}
+	now := time.Now()
+	c.FinishedAt = &now
+	if err := c.save(); err != nil {
+		storeLog.Errorf("Saving crawl %s failed: %v", c.ID.Hex(), err)
+	}
+}
+
+func (c *Crawl) save() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("crawls")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": c.ID}, c, opts)
+	return err
+}
+
+// loadProgress counts the jobs of the crawl by status.
+func (c *Crawl) loadProgress() error {
+	c.Progress = make(map[string]int)
+	if len(c.Jobs) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	collection := DB.Database("websu").Collection("jobs")
+	opts := options.Find().SetProjection(bson.M{"status": 1})
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": c.Jobs}}, opts)
+	if err != nil {
+		return err
+	}
+	jobs := []Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		c.Progress[job.Status]++
+	}
+	return nil
+}
+
+func GetCrawlByObjectIDHex(hex string) (Crawl, error) {
+	var crawl Crawl
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return crawl, err
+	}
+	collection := DB.Database("websu").Collection("crawls")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&crawl)
+	return crawl, err
+}
+
+// createCrawl starts a crawl in the background. By default it scans up to
+// 100 pages one at a time, following links 1 level deep when crawling from
+// a url. The crawl is tracked with GET /crawls/{id}.
+func (a *App) createCrawl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	// Depth defaults to 1 while an explicit 0 only scans url.
+	crawl := Crawl{Depth: -1}
+	if err := decodeJSONBody(w, r, &crawl); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if crawl.Depth == -1 {
+		crawl.Depth = 1
+	}
+	if err := crawl.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if crawl.MaxPages == 0 {
+		crawl.MaxPages = defaultCrawlMaxPages
+	}
+	if crawl.Concurrency == 0 {
+		crawl.Concurrency = defaultCrawlConcurrency
+	}
+	crawl.ID = primitive.NewObjectID()
+	crawl.Status = JobRunning
+	crawl.Pages = []string{}
+	crawl.Jobs = []primitive.ObjectID{}
+	crawl.Skipped, crawl.Error, crawl.FinishedAt = nil, "", nil
+	crawl.CreatedAt = time.Now()
+	if err := crawl.save(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	running := crawl
+	go running.run(a.Workers)
+	crawl.Progress = map[string]int{}
+	w.Header().Set("Location", "/crawls/"+crawl.ID.Hex())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&crawl)
+}
+
+func (a *App) getCrawl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	crawl, err := GetCrawlByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err := crawl.loadProgress(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&crawl)
+}
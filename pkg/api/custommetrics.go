@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var metricNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// MetricDefinition is a custom metric extracted from the Lighthouse report
+// of every scan on ingest, e.g. the transfer size of a specific resource:
+//
+//	$.audits.network-requests.details.items[?(@.url=='https://example.com/app.js')].transferSize
+//
+// Path supports a subset of JSONPath: child names with .name or ['name'],
+// array indexes with [n] and picking the first array element whose field
+// equals a string with [?(@.field=='value')]. Custom metrics are used like
+// the built-in metrics, where lower values are considered better.
+type MetricDefinition struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	Path      string             `json:"path" bson:"path"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type pathSegment struct {
+	key         string
+	index       int
+	filterField string
+	filterValue string
+}
+
+// parsePath splits a JSONPath expression into its segments.
+func parsePath(path string) ([]pathSegment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("Path must start with $")
+	}
+	segments := []pathSegment{}
+	rest := path[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("Path %s contains an empty name", path)
+			}
+			segments = append(segments, pathSegment{key: rest[1 : end+1], index: -1})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "[?(@."):
+			end := strings.Index(rest, ")]")
+			if end == -1 {
+				return nil, fmt.Errorf("Path %s contains an unterminated filter", path)
+			}
+			parts := strings.SplitN(rest[len("[?(@."):end], "==", 2)
+			if len(parts) != 2 || len(parts[1]) < 2 || parts[1][0] != '\'' || parts[1][len(parts[1])-1] != '\'' {
+				return nil, fmt.Errorf("Path %s contains an unsupported filter, expected [?(@.field=='value')]", path)
+			}
+			segments = append(segments, pathSegment{index: -1, filterField: parts[0],
+				filterValue: parts[1][1 : len(parts[1])-1]})
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "['"):
+			end := strings.Index(rest, "']")
+			if end == -1 {
+				return nil, fmt.Errorf("Path %s contains an unterminated name", path)
+			}
+			segments = append(segments, pathSegment{key: rest[2:end], index: -1})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("Path %s contains an unterminated index", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("Path %s contains an invalid index %s", path, rest[1:end])
+			}
+			segments = append(segments, pathSegment{index: index})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("Path %s could not be parsed at %s", path, rest)
+		}
+	}
+	return segments, nil
+}
+
+// evalPath returns the number path points to in the decoded JSON value,
+// or nil when it doesn't point to a number.
+func evalPath(value interface{}, segments []pathSegment) *float64 {
+	for _, s := range segments {
+		switch {
+		case s.key != "":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			value = object[s.key]
+		case s.filterField != "":
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil
+			}
+			value = nil
+			for _, element := range array {
+				if object, ok := element.(map[string]interface{}); ok && object[s.filterField] == s.filterValue {
+					value = element
+					break
+				}
+			}
+		default:
+			array, ok := value.([]interface{})
+			if !ok || s.index >= len(array) {
+				return nil
+			}
+			value = array[s.index]
+		}
+	}
+	if number, ok := value.(float64); ok {
+		return &number
+	}
+	return nil
+}
+
+func (d *MetricDefinition) validate() error {
+	if !metricNamePattern.MatchString(d.Name) {
+		return errors.New("Metric name must only contain lowercase letters, digits, _ and -")
+	}
+	if categoryNames[d.Name] || metricNames[d.Name] {
+		return errors.New("Metric name " + d.Name + " is already used by a built-in metric")
+	}
+	_, err := parsePath(d.Path)
+	return err
+}
+
+func GetAllMetricDefinitions() ([]MetricDefinition, error) {
+	definitions := []MetricDefinition{}
+	collection := DB.Database("websu").Collection("metric_definitions")
+	c := context.TODO()
+	cursor, err := collection.Find(c, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &definitions); err != nil {
+		return nil, err
+	}
+	return definitions, nil
+}
+
+func GetMetricDefinitionByObjectIDHex(hex string) (MetricDefinition, error) {
+	var d MetricDefinition
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return d, err
+	}
+	collection := DB.Database("websu").Collection("metric_definitions")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&d)
+	return d, err
+}
+
+// isMetricName reports whether name is a built-in score or metric or a
+// defined custom metric.
+func isMetricName(name string) (bool, error) {
+	if categoryNames[name] || metricNames[name] {
+		return true, nil
+	}
+	collection := DB.Database("websu").Collection("metric_definitions")
+	err := collection.FindOne(context.Background(), bson.M{"name": name}).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *MetricDefinition) Insert() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("metric_definitions")
+	_, err := collection.InsertOne(ctx, d)
+	if isDuplicateKey(err) {
+		return &alreadyExistsError{"Metric " + d.Name + " is already defined"}
+	}
+	return err
+}
+
+func (d *MetricDefinition) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("metric_definitions")
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": d.ID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound("Metric definition", d.ID)
+	}
+	return nil
+}
+
+// customMetricsFromReport extracts all defined custom metrics from the raw
+// Lighthouse report. Metrics whose path doesn't point to a number are left
+// out.
+func customMetricsFromReport(data []byte) (map[string]float64, error) {
+	definitions, err := GetAllMetricDefinitions()
+	if err != nil || len(definitions) == 0 {
+		return nil, err
+	}
+	var report interface{}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]float64)
+	for _, d := range definitions {
+		segments, err := parsePath(d.Path)
+		if err != nil {
+			return nil, err
+		}
+		if v := evalPath(report, segments); v != nil {
+			metrics[d.Name] = *v
+		}
+	}
+	return metrics, nil
+}
+
+func (a *App) getMetricDefinitions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	definitions, err := GetAllMetricDefinitions()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&definitions)
+}
+
+func (a *App) createMetricDefinition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var d MetricDefinition
+	if err := decodeJSONBody(w, r, &d); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := d.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.ID = primitive.NewObjectID()
+	d.CreatedAt = time.Now()
+	if err := d.Insert(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&d)
+}
+
+func (a *App) getMetricDefinition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	d, err := GetMetricDefinitionByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&d)
+}
+
+func (a *App) deleteMetricDefinition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	d, err := GetMetricDefinitionByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err := d.Delete(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&MetricDefinition{})
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// scanDedupWindow returns SCAN_DEDUP_WINDOW, the number of minutes within
+// which a new scan of a URL is answered with its last scan. It is 0, which
+// disables deduplication, unless set.
+func scanDedupWindow() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("SCAN_DEDUP_WINDOW"))
+	if err != nil || minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// reusable reports whether scan runs with the default settings, so that it
+// can stand in for another scan of its URL with the default settings.
+// Scans that belong to a project or are tagged, labelled or annotated are
+// requested for their own sake and are never shared.
+func (scan *Scan) reusable() bool {
+	return scan.runnerType() == defaultRunnerType() && scan.ChromeProfile == "" &&
+		len(scan.ProtocolPresets) == 0 && len(scan.HostOverrides) == 0 && scan.Options == nil &&
+		scan.TargetAuth == nil && scan.EncryptedTargetAuth == "" &&
+		scan.Project == nil && len(scan.Tags) == 0 && scan.Label == "" && scan.Notes == ""
+}
+
+// cachedJob returns the job of the newest scan that succeeded within the
+// dedup window and can stand in for scan, or nil when there is none.
+func cachedJob(scan *Scan) (*Job, error) {
+	window := scanDedupWindow()
+	if window == 0 || !scan.reusable() {
+		return nil, nil
+	}
+	scans, err := Scans.List(ScanQuery{
+		URL:          scan.URL,
+		Status:       ScanSucceeded,
+		CreatedAfter: time.Now().Add(-window),
+		Sort:         bson.D{{Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, cached := range scans {
+		if !cached.reusable() {
+			continue
+		}
+		job, err := GetJobByScanID(cached.ID)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		return &job, err
+	}
+	return nil, nil
+}
+
+// GetJobByScanID returns the job that ran the scan with the given ID.
+func GetJobByScanID(id primitive.ObjectID) (Job, error) {
+	var job Job
+	collection := DB.Database("websu").Collection("jobs")
+	err := collection.FindOne(context.Background(), bson.M{"scan_id": id}).Decode(&job)
+	return job, err
+}
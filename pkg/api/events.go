@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	EventStatus   = "status"
+	EventProgress = "progress"
+)
+
+// ScanEvent is a status transition of a scan or a progress line Lighthouse
+// logged while running it.
+type ScanEvent struct {
+	Type    string `json:"type"`
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// scanEvents delivers the events of running scans, and the scans once they
+// completed, to the clients streaming them. Events are only kept in memory,
+// clients that can't keep up miss events.
+var scanEvents = &eventBus{
+	subscribers: make(map[primitive.ObjectID]map[chan ScanEvent]bool),
+	completed:   make(map[chan *Scan]bool),
+}
+
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[chan ScanEvent]bool
+	completed   map[chan *Scan]bool
+}
+
+func (b *eventBus) subscribe(id primitive.ObjectID) chan ScanEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan ScanEvent, 64)
+	if b.subscribers[id] == nil {
+		b.subscribers[id] = make(map[chan ScanEvent]bool)
+	}
+	b.subscribers[id][ch] = true
+	return ch
+}
+
+func (b *eventBus) unsubscribe(id primitive.ObjectID, ch chan ScanEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers[id], ch)
+	if len(b.subscribers[id]) == 0 {
+		delete(b.subscribers, id)
+	}
+}
+
+func (b *eventBus) publish(id primitive.ObjectID, event ScanEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[id] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (b *eventBus) publishStatus(scan *Scan) {
+	b.publish(scan.ID, ScanEvent{Type: EventStatus, Status: scan.Status, Error: scan.Error})
+}
+
+func (b *eventBus) subscribeCompleted() chan *Scan {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan *Scan, 16)
+	b.completed[ch] = true
+	return ch
+}
+
+func (b *eventBus) unsubscribeCompleted(ch chan *Scan) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.completed, ch)
+}
+
+// publishCompleted hands a scan that finished running to every subscriber.
+func (b *eventBus) publishCompleted(scan *Scan) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.completed {
+		select {
+		case ch <- scan:
+		default:
+		}
+	}
+}
+
+// isFinished reports whether status is the final status of a scan.
+func isFinished(status string) bool {
+	return status != ScanPending && status != ScanRunning
+}
+
+// progressWriter publishes every line written to it as a progress event of
+// the scan, without the target credentials Lighthouse logs with the URL.
+type progressWriter struct {
+	scan *Scan
+	line bytes.Buffer
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.line.Write(data)
+	for {
+		i := bytes.IndexByte(p.line.Bytes(), '\n')
+		if i < 0 {
+			return len(data), nil
+		}
+		line := strings.TrimSpace(string(p.scan.scrubTargetAuth(p.line.Next(i + 1))))
+		if line != "" {
+			scanEvents.publish(p.scan.ID, ScanEvent{Type: EventProgress, Message: line})
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event ScanEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	w.(http.Flusher).Flush()
+}
+
+// getScanEvents streams the status transitions and Lighthouse progress of a
+// scan as Server-Sent Events until the scan finishes.
+func (a *App) getScanEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeError(w, r, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	id, err := parseObjectID(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	// Subscribe before reading the scan so no transition is missed.
+	events := scanEvents.subscribe(id)
+	defer scanEvents.unsubscribe(id, events)
+	scan, err := Scans.Get(id)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeEvent(w, ScanEvent{Type: EventStatus, Status: scan.Status, Error: scan.Error})
+	if isFinished(scan.Status) {
+		return
+	}
+	for {
+		select {
+		case event := <-events:
+			writeEvent(w, event)
+			if event.Type == EventStatus && isFinished(event.Status) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
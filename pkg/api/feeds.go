@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const feedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// baseURL returns the scheme and host the request was made to, honoring
+// X-Forwarded-Proto when running behind a proxy.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+func (a *App) getScansFeed(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{"status": finishedScans}
+	if url := r.URL.Query().Get("url"); url != "" {
+		filter["url"] = url
+	}
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(feedEntries)
+	scans, err := FindAnalyticsScans(QueryReports, filter, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	base := baseURL(r)
+	feed := atomFeed{
+		ID:      base + r.URL.RequestURI(),
+		Title:   "Completed scans",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: base + r.URL.RequestURI(), Rel: "self"},
+	}
+	if len(scans) > 0 {
+		feed.Updated = scans[0].CreatedAt.UTC().Format(time.RFC3339)
+	}
+	for _, scan := range scans {
+		link := base + "/scans/" + scan.ID.Hex()
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      link,
+			Title:   "Scan of " + scan.URL,
+			Updated: scan.CreatedAt.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+			Summary: "Lighthouse report stored at " + scan.JsonLocation,
+		})
+	}
+	w.Header().Set("Content-Type", "application/atom+xml")
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(&feed)
+}
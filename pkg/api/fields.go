@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// scanFields maps the JSON name of every stored Scan field to its BSON
+// name, for selecting fields with ?fields=.
+var scanFields = storedFields(reflect.TypeOf(Scan{}))
+
+func storedFields(t reflect.Type) map[string]string {
+	fields := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		bsonName := strings.Split(f.Tag.Get("bson"), ",")[0]
+		if jsonName == "" || jsonName == "-" || bsonName == "" || bsonName == "-" {
+			continue
+		}
+		fields[jsonName] = bsonName
+	}
+	return fields
+}
+
+// parseFields reads the comma separated ?fields= query parameter. It
+// returns the requested JSON field names and the matching Mongo
+// projection, or nil for both when all fields are requested.
+func parseFields(r *http.Request) ([]string, bson.M, error) {
+	param := r.URL.Query().Get("fields")
+	if param == "" {
+		return nil, nil, nil
+	}
+	fields := strings.Split(param, ",")
+	projection := bson.M{}
+	for _, field := range fields {
+		bsonName, ok := scanFields[field]
+		if !ok {
+			return nil, nil, errors.New("Unknown field " + field)
+		}
+		projection[bsonName] = 1
+	}
+	return fields, projection, nil
+}
+
+// selectFields returns the JSON representation of each scan reduced to
+// the given fields.
+func selectFields(scans []Scan, fields []string) ([]map[string]interface{}, error) {
+	selected := make([]map[string]interface{}, len(scans))
+	for i, scan := range scans {
+		data, err := json.Marshal(&scan)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]interface{}
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		selected[i] = make(map[string]interface{})
+		for _, field := range fields {
+			if v, ok := all[field]; ok {
+				selected[i][field] = v
+			}
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultFlakinessDays      = 30
+	defaultFlakinessThreshold = 0.2
+)
+
+// AuditFlakiness reports how often the audits of a URL flipped between
+// passing and failing on consecutive scans. Audits are sorted by flip rate,
+// highest first.
+type AuditFlakiness struct {
+	URL       string      `json:"url"`
+	Days      int         `json:"days"`
+	Threshold float64     `json:"threshold"`
+	Audits    []AuditFlip `json:"audits"`
+}
+
+// AuditFlip counts the runs of an audit. FlipRate is the share of
+// consecutive runs with a different result, and the audit is Flaky when it
+// reaches the threshold.
+type AuditFlip struct {
+	Audit    string  `json:"audit"`
+	Runs     int     `json:"runs"`
+	Passes   int     `json:"passes"`
+	Flips    int     `json:"flips"`
+	FlipRate float64 `json:"flip_rate"`
+	Flaky    bool    `json:"flaky"`
+}
+
+// count computes the flips of every audit in scans, which are sorted by
+// creation time.
+func (f *AuditFlakiness) count(scans []Scan) {
+	flips := make(map[string]*AuditFlip)
+	last := make(map[string]bool)
+	for _, scan := range scans {
+		for audit, passed := range scan.AuditResults {
+			flip, ok := flips[audit]
+			if !ok {
+				flip = &AuditFlip{Audit: audit}
+				flips[audit] = flip
+			} else if last[audit] != passed {
+				flip.Flips++
+			}
+			flip.Runs++
+			if passed {
+				flip.Passes++
+			}
+			last[audit] = passed
+		}
+	}
+	f.Audits = []AuditFlip{}
+	for _, flip := range flips {
+		if flip.Runs > 1 {
+			flip.FlipRate = float64(flip.Flips) / float64(flip.Runs-1)
+		}
+		flip.Flaky = flip.Flips > 0 && flip.FlipRate >= f.Threshold
+		f.Audits = append(f.Audits, *flip)
+	}
+	sort.Slice(f.Audits, func(i, j int) bool {
+		if f.Audits[i].FlipRate != f.Audits[j].FlipRate {
+			return f.Audits[i].FlipRate > f.Audits[j].FlipRate
+		}
+		return f.Audits[i].Audit < f.Audits[j].Audit
+	})
+}
+
+// getAuditFlakiness reports the flip rates of the audits of ?url over the
+// scans of the last ?days days, 30 by default. Audits flipping at least
+// ?threshold of the time, 0.2 by default, are marked as flaky.
+func (a *App) getAuditFlakiness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	days, err := parseTrendQuery(r, defaultFlakinessDays, "url")
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	f := AuditFlakiness{URL: query.Get("url"), Days: days, Threshold: defaultFlakinessThreshold}
+	if t := query.Get("threshold"); t != "" {
+		if f.Threshold, err = strconv.ParseFloat(t, 64); err != nil || f.Threshold <= 0 || f.Threshold > 1 {
+			writeError(w, r, "threshold must be a number between 0 and 1", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := bson.M{"url": f.URL, "audit_results": bson.M{"$exists": true}}
+	scans, err := findTrendScans(QueryReports, filter, days, "audit_results")
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	f.count(scans)
+	json.NewEncoder(w).Encode(&f)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultForecastDays = 30
+
+// Forecast projects when a score or metric of a URL crosses its budget if
+// it keeps changing at the rate of a linear fit over the recent scans.
+// CrossesAt is null when the trend moves away from the budget or there are
+// not enough scans to fit one.
+type Forecast struct {
+	URL         string     `json:"url"`
+	Metric      string     `json:"metric"`
+	Budget      float64    `json:"budget"`
+	Days        int        `json:"days"`
+	Samples     int        `json:"samples"`
+	SlopePerDay *float64   `json:"slope_per_day"`
+	Projected   *float64   `json:"projected"`
+	OverBudget  bool       `json:"over_budget"`
+	CrossesAt   *time.Time `json:"crosses_at"`
+}
+
+// fit computes the linear regression of the metric over time of scans,
+// which are sorted by creation time.
+func (f *Forecast) fit(scans []Scan, now time.Time) {
+	var xs, ys []float64
+	var start time.Time
+	for _, scan := range scans {
+		v := scan.Value(f.Metric)
+		if v == nil {
+			continue
+		}
+		if len(xs) == 0 {
+			start = scan.CreatedAt
+		}
+		xs = append(xs, scan.CreatedAt.Sub(start).Hours()/24)
+		ys = append(ys, *v)
+	}
+	f.Samples = len(xs)
+	if f.Samples < 2 {
+		return
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+	n := float64(f.Samples)
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		// All scans were taken at the same time.
+		return
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	intercept := (sumY - slope*sumX) / n
+	projected := intercept + slope*now.Sub(start).Hours()/24
+	f.SlopePerDay, f.Projected = &slope, &projected
+
+	worsening := slope > 0
+	if higherIsBetter(f.Metric) {
+		f.OverBudget = projected < f.Budget
+		worsening = slope < 0
+	} else {
+		f.OverBudget = projected > f.Budget
+	}
+	if f.OverBudget || !worsening {
+		return
+	}
+	days := (f.Budget - intercept) / slope
+	crossesAt := start.Add(time.Duration(days * 24 * float64(time.Hour)))
+	f.CrossesAt = &crossesAt
+}
+
+// getForecast projects when ?metric of ?url crosses ?budget, based on the
+// scans of the last ?days days, 30 by default.
+func (a *App) getForecast(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	f := Forecast{URL: query.Get("url"), Metric: query.Get("metric"), Days: defaultForecastDays}
+	if f.URL == "" {
+		writeError(w, r, "Query parameter url is required", http.StatusBadRequest)
+		return
+	}
+	if f.Metric == "" {
+		f.Metric = "performance"
+	}
+	known, err := isMetricName(f.Metric)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	} else if !known {
+		writeError(w, r, "Unknown metric "+f.Metric, http.StatusBadRequest)
+		return
+	}
+	if f.Budget, err = strconv.ParseFloat(query.Get("budget"), 64); err != nil {
+		writeError(w, r, "Query parameter budget must be a number", http.StatusBadRequest)
+		return
+	}
+	if d := query.Get("days"); d != "" {
+		if f.Days, err = strconv.Atoi(d); err != nil || f.Days <= 0 {
+			writeError(w, r, "days must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	now := time.Now()
+	filter := bson.M{
+		"url":        f.URL,
+		"status":     finishedScans,
+		"created_at": bson.M{"$gte": now.AddDate(0, 0, -f.Days)},
+	}
+	scans, err := FindAnalyticsScans(QueryTrends, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	f.fit(scans, now)
+	json.NewEncoder(w).Encode(&f)
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SiteGroup is a named set of URLs, e.g. the pages of a checkout funnel,
+// that is scored as a whole.
+type SiteGroup struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	Name      string             `json:"name" bson:"name"`
+	URLs      []string           `json:"urls" bson:"urls"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// GroupScore is the average of the latest score of every URL in a group.
+// Average is null when none of the URLs has a scored scan.
+type GroupScore struct {
+	GroupID primitive.ObjectID `json:"group_id"`
+	Metric  string             `json:"metric"`
+	Average *float64           `json:"average"`
+	URLs    []URLScore         `json:"urls"`
+}
+
+type URLScore struct {
+	URL    string              `json:"url"`
+	ScanID *primitive.ObjectID `json:"scan_id"`
+	Score  *float64            `json:"score"`
+}
+
+func (g *SiteGroup) validate() error {
+	if g.Name == "" {
+		return errors.New("Group name must not be empty")
+	}
+	if len(g.URLs) == 0 {
+		return errors.New("Group must contain at least one URL")
+	}
+	return nil
+}
+
+func GetAllGroups() ([]SiteGroup, error) {
+	groups := []SiteGroup{}
+	collection := DB.Database("websu").Collection("groups")
+	c := context.TODO()
+	cursor, err := collection.Find(c, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func GetGroupByObjectIDHex(hex string) (SiteGroup, error) {
+	var group SiteGroup
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return group, err
+	}
+	collection := DB.Database("websu").Collection("groups")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&group)
+	return group, err
+}
+
+func (g *SiteGroup) Insert() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("groups")
+	storeLog.Debugf("Inserting SiteGroup: %+v", g)
+	_, err := collection.InsertOne(ctx, g)
+	return err
+}
+
+func (g *SiteGroup) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("groups")
+	update := bson.M{"$set": bson.M{"name": g.Name, "urls": g.URLs}}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": g.ID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound("Group", g.ID)
+	}
+	return nil
+}
+
+func (g *SiteGroup) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("groups")
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": g.ID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound("Group", g.ID)
+	}
+	return nil
+}
+
+// Score computes the average of the latest metric score of every URL in
+// the group.
+func (g *SiteGroup) Score(metric string) (*GroupScore, error) {
+	gs := &GroupScore{GroupID: g.ID, Metric: metric, URLs: []URLScore{}}
+	var sum float64
+	var scored int
+	for _, url := range g.URLs {
+		scan, scores, err := latestScores(url, []string{metric})
+		if err != nil {
+			return nil, err
+		}
+		us := URLScore{URL: url, Score: scores[0]}
+		if scan != nil {
+			us.ScanID = &scan.ID
+		}
+		if us.Score != nil {
+			sum += *us.Score
+			scored++
+		}
+		gs.URLs = append(gs.URLs, us)
+	}
+	if scored > 0 {
+		average := sum / float64(scored)
+		gs.Average = &average
+	}
+	return gs, nil
+}
+
+func (a *App) getGroups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	groups, err := GetAllGroups()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&groups)
+}
+
+func (a *App) createGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var group SiteGroup
+	if err := decodeJSONBody(w, r, &group); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := group.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.ID = primitive.NewObjectID()
+	group.CreatedAt = time.Now()
+	if err := group.Insert(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&group)
+}
+
+func (a *App) getGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, err := GetGroupByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&group)
+}
+
+func (a *App) updateGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, err := GetGroupByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var update SiteGroup
+	if err := decodeJSONBody(w, r, &update); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := update.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.Name = update.Name
+	group.URLs = update.URLs
+	if err := group.Update(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&group)
+}
+
+func (a *App) deleteGroup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, err := GetGroupByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err := group.Delete(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&SiteGroup{})
+}
+
+func (a *App) getGroupScore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	group, err := GetGroupByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "performance"
+	}
+	known, err := isMetricName(metric)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	} else if !known {
+		writeError(w, r, "Unknown metric "+metric, http.StatusBadRequest)
+		return
+	}
+	score, err := group.Score(metric)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(score)
+}
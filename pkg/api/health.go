@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+// Readiness is the result of GET /readyz. Checks maps every dependency to
+// "ok" or the reason it isn't usable.
+type Readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// getHealthz reports that the process is up, for liveness probes.
+func (a *App) getHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// getReadyz reports whether scans can be served: Mongo must answer a ping
+// and the lighthouse binary must be on the PATH.
+func (a *App) getReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	readiness := Readiness{Ready: true, Checks: map[string]string{"mongo": "ok", "lighthouse": "ok"}}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if DB == nil {
+		readiness.Ready, readiness.Checks["mongo"] = false, "not connected"
+	} else if err := DB.Ping(ctx, nil); err != nil {
+		readiness.Ready, readiness.Checks["mongo"] = false, err.Error()
+	}
+	if _, err := exec.LookPath("lighthouse"); err != nil {
+		readiness.Ready, readiness.Checks["lighthouse"] = false, err.Error()
+	}
+	if !readiness.Ready {
+		httpLog.Warnf("Not ready: %v", readiness.Checks)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(&readiness)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// scanIndexes are the indexes of the scans collection backing the list,
+// filter and trend queries.
+var scanIndexes = []bson.D{
+	{{Key: "url", Value: 1}},
+	{{Key: "created_at", Value: 1}},
+	{{Key: "status", Value: 1}},
+	{{Key: "project", Value: 1}},
+	{{Key: "tags", Value: 1}},
+	{{Key: "url", Value: 1}, {Key: "created_at", Value: 1}},
+}
+
+// metricDefinitionIndexes are the unique indexes of the metric_definitions
+// collection. They keep two concurrent definitions of a name from both
+// being stored.
+var metricDefinitionIndexes = []bson.D{
+	{{Key: "name", Value: 1}},
+}
+
+// CreateIndexes creates the indexes of the scans and metric_definitions
+// collections that don't exist yet. Indexes are matched by their keys, so
+// indexes created by hand under another name are kept.
+func CreateIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	for _, c := range []struct {
+		name    string
+		indexes []bson.D
+		opts    *options.IndexOptions
+	}{
+		{"scans", scanIndexes, nil},
+		{"metric_definitions", metricDefinitionIndexes, options.Index().SetUnique(true)},
+	} {
+		created, err := ensureIndexes(ctx, DB.Database("websu").Collection(c.name), c.indexes, c.opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, name := range created {
+			storeLog.Infof("Created index %s on %s", name, c.name)
+		}
+	}
+}
+
+// ensureIndexes creates the indexes of collection that don't exist yet
+// with opts.
+func ensureIndexes(ctx context.Context, collection *mongo.Collection, indexes []bson.D, opts *options.IndexOptions) ([]string, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var existing []struct {
+		Key bson.D `bson:"key"`
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, index := range existing {
+		exists[indexKey(index.Key)] = true
+	}
+	models := []mongo.IndexModel{}
+	for _, keys := range indexes {
+		if !exists[indexKey(keys)] {
+			models = append(models, mongo.IndexModel{Keys: keys, Options: opts})
+		}
+	}
+	if len(models) == 0 {
+		return nil, nil
+	}
+	return collection.Indexes().CreateMany(ctx, models, options.CreateIndexes())
+}
+
+// indexKey identifies an index by its keys, e.g. url_1_created_at_1.
+func indexKey(keys bson.D) string {
+	parts := make([]string, len(keys))
+	for i, e := range keys {
+		parts[i] = fmt.Sprintf("%s_%v", e.Key, e.Value)
+	}
+	return strings.Join(parts, "_")
+}
+
+// isDuplicateKey reports whether err is the error of a write violating a
+// unique index.
+func isDuplicateKey(err error) bool {
+	var writeErr mongo.WriteException
+	if !errors.As(err, &writeErr) {
+		return false
+	}
+	for _, e := range writeErr.WriteErrors {
+		if e.Code == 11000 {
+			return true
+		}
+	}
+	return false
+}
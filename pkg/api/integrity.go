@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	IssueMissingReport = "missing_report"
+	IssueStuckJob      = "stuck_job"
+)
+
+// IntegrityCheck is the report of a run of the integrity checker, which
+// looks for scans whose report is missing from Reports and jobs that have
+// been queued or running for longer than StuckAfterMinutes.
+type IntegrityCheck struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id"`
+	Status            string             `json:"status" bson:"status"`
+	Repair            bool               `json:"repair" bson:"repair"`
+	StuckAfterMinutes int                `json:"stuck_after_minutes" bson:"stuck_after_minutes"`
+	Issues            []IntegrityIssue   `json:"issues" bson:"issues"`
+	Error             string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+	FinishedAt        *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+type IntegrityIssue struct {
+	Kind     string             `json:"kind" bson:"kind"`
+	ID       primitive.ObjectID `json:"id" bson:"id"`
+	Detail   string             `json:"detail" bson:"detail"`
+	Repaired bool               `json:"repaired" bson:"repaired"`
+}
+
+func (c *IntegrityCheck) run() {
+	err := c.checkReports()
+	if err == nil {
+		err = c.checkJobs()
+	}
+	c.Status = JobDone
+	if err != nil {
+		storeLog.Errorf("Integrity check %s failed: %v", c.ID.Hex(), err)
+		c.Status, c.Error = JobFailed, err.Error()
+	}
+	now := time.Now()
+	c.FinishedAt = &now
+	if err := c.save(); err != nil {
+		storeLog.Errorf("Saving integrity check %s failed: %v", c.ID.Hex(), err)
+	}
+}
+
+// checkReports flags scans whose report no longer exists in Reports. Repairing
+// drops the dangling reference.
+func (c *IntegrityCheck) checkReports() error {
+	filter := bson.M{"jsonLocation": bson.M{"$exists": true, "$ne": ""}}
+	scans, err := FindScans(filter, options.Find())
+	if err != nil {
+		return err
+	}
+	for _, scan := range scans {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		exists, err := Reports.Exists(ctx, scan.JsonLocation)
+		cancel()
+		if err == nil && !exists {
+			issue := IntegrityIssue{Kind: IssueMissingReport, ID: scan.ID,
+				Detail: "Report " + scan.JsonLocation + " does not exist"}
+			if c.Repair {
+				if err := scan.unsetReport(); err != nil {
+					return err
+				}
+				issue.Repaired = true
+			}
+			c.Issues = append(c.Issues, issue)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkJobs flags jobs that are still queued or running after
+// StuckAfterMinutes, e.g. because the server restarted while they ran.
+// Repairing marks them as failed.
+func (c *IntegrityCheck) checkJobs() error {
+	filter := bson.M{
+		"status":     bson.M{"$in": []string{JobQueued, JobRunning}},
+		"created_at": bson.M{"$lt": c.CreatedAt.Add(-time.Duration(c.StuckAfterMinutes) * time.Minute)},
+	}
+	collection := DB.Database("websu").Collection("jobs")
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	jobs := []Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		issue := IntegrityIssue{Kind: IssueStuckJob, ID: job.ID,
+			Detail: "Job has been " + job.Status + " since " + job.CreatedAt.Format(time.RFC3339)}
+		if c.Repair {
+			msg := "Job was stuck and marked as failed by integrity check " + c.ID.Hex()
+			if err := job.setStatus(JobFailed, msg); err != nil {
+				return err
+			}
+			scan := Scan{ID: job.ScanID}
+			if err := scan.setStatus(ScanFailed, msg); err != nil {
+				return err
+			}
+			issue.Repaired = true
+		}
+		c.Issues = append(c.Issues, issue)
+	}
+	return nil
+}
+
+func (c *IntegrityCheck) save() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("integrity_checks")
+	opts := options.Replace().SetUpsert(true)
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": c.ID}, c, opts)
+	return err
+}
+
+func GetIntegrityCheckByObjectIDHex(hex string) (IntegrityCheck, error) {
+	var check IntegrityCheck
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return check, err
+	}
+	collection := DB.Database("websu").Collection("integrity_checks")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&check)
+	return check, err
+}
+
+// createIntegrityCheck starts an integrity check in the background. It
+// only reports issues unless ?repair=true is given. Jobs count as stuck
+// after ?stuck_after minutes, 60 by default.
+func (a *App) createIntegrityCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	stuckAfter := 60
+	if s := query.Get("stuck_after"); s != "" {
+		var err error
+		if stuckAfter, err = strconv.Atoi(s); err != nil || stuckAfter <= 0 {
+			writeError(w, r, "stuck_after must be a positive number of minutes", http.StatusBadRequest)
+			return
+		}
+	}
+	check := &IntegrityCheck{
+		ID:                primitive.NewObjectID(),
+		Status:            JobRunning,
+		Repair:            query.Get("repair") == "true",
+		StuckAfterMinutes: stuckAfter,
+		Issues:            []IntegrityIssue{},
+		CreatedAt:         time.Now(),
+	}
+	if err := check.save(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	running := *check
+	go running.run()
+	w.Header().Set("Location", "/admin/integrity-checks/"+check.ID.Hex())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(check)
+}
+
+func (a *App) getIntegrityCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	check, err := GetIntegrityCheckByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&check)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+	// JobCancelled jobs were cancelled with DELETE /jobs/{id}.
+	JobCancelled = "cancelled"
+	// JobInterrupted jobs were running when the server stopped.
+	JobInterrupted = "interrupted"
+)
+
+// Job tracks a scan that runs in the background. Once the job is done the
+// scan can be fetched with GET /scans/{scan_id}.
+type Job struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Status     string             `json:"status" bson:"status"`
+	URL        string             `json:"url" bson:"url"`
+	ScanID     primitive.ObjectID `json:"scan_id" bson:"scan_id"`
+	Error      string             `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	StartedAt  *time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
+
+func (job *Job) run(ctx context.Context, scan *Scan) {
+	// Queued jobs can be cancelled until they are marked as running.
+	if started, err := job.setStatusFrom([]string{JobQueued}, JobRunning, ""); err != nil {
+		storeLog.Errorf("Marking job %s as running failed: %v", job.ID.Hex(), err)
+	} else if !started {
+		engineLog.Infof("Job %s for scan %s was cancelled before it started", job.ID.Hex(), scan.ID.Hex())
+		return
+	}
+	status, errMsg := JobDone, ""
+	if err := executeScan(ctx, scan); interrupted(ctx) {
+		engineLog.Warnf("Job %s for scan %s was interrupted by the shutdown", job.ID.Hex(), scan.ID.Hex())
+		status, errMsg = JobInterrupted, errInterrupted
+	} else if ctx.Err() == context.Canceled {
+		engineLog.Infof("Job %s for scan %s was cancelled", job.ID.Hex(), scan.ID.Hex())
+		status, errMsg = JobCancelled, errCancelled
+	} else if err != nil {
+		engineLog.Errorf("Job %s for scan %s failed: %v", job.ID.Hex(), scan.ID.Hex(), err)
+		status, errMsg = JobFailed, err.Error()
+	}
+	if err := job.setStatus(status, errMsg); err != nil {
+		storeLog.Errorf("Marking job %s as %s failed: %v", job.ID.Hex(), status, err)
+	}
+	notifyWebhooks(scan)
+	scanEvents.publishCompleted(scan)
+}
+
+// abort marks the job and its scan as failed without running it.
+func (job *Job) abort(scan *Scan, reason string) {
+	job.abortAs(JobFailed, scan, reason)
+}
+
+// abortAs marks the job with status and its scan as failed.
+func (job *Job) abortAs(status string, scan *Scan, reason string) {
+	if err := job.setStatus(status, reason); err != nil {
+		storeLog.Errorf("Marking job %s as %s: %v", job.ID.Hex(), status, err)
+	}
+	if err := scan.setStatus(ScanFailed, reason); err != nil {
+		storeLog.Errorf("Marking scan %s as failed: %v", scan.ID.Hex(), err)
+	}
+}
+
+func (job *Job) Insert() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("jobs")
+	storeLog.Debugf("Inserting Job: %+v", job)
+	_, err := collection.InsertOne(ctx, job)
+	return err
+}
+
+func (job *Job) setStatus(status string, errMsg string) error {
+	_, err := job.setStatusFrom(nil, status, errMsg)
+	return err
+}
+
+// setStatusFrom sets the status of the job if its stored status is one of
+// from, or whatever it is when from is empty. It reports whether the job
+// was updated.
+func (job *Job) setStatusFrom(from []string, status string, errMsg string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	now := time.Now()
+	set := bson.M{"status": status}
+	switch status {
+	case JobRunning:
+		set["started_at"] = now
+	case JobDone, JobFailed, JobCancelled, JobInterrupted:
+		set["finished_at"] = now
+	}
+	if errMsg != "" {
+		set["error"] = errMsg
+	}
+	filter := bson.M{"_id": job.ID}
+	if len(from) > 0 {
+		filter["status"] = bson.M{"$in": from}
+	}
+	collection := DB.Database("websu").Collection("jobs")
+	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil || result.MatchedCount == 0 {
+		return false, err
+	}
+	switch status {
+	case JobRunning:
+		job.StartedAt = &now
+	case JobDone, JobFailed, JobCancelled, JobInterrupted:
+		job.FinishedAt = &now
+	}
+	if errMsg != "" {
+		job.Error = errMsg
+	}
+	job.Status = status
+	return true, nil
+}
+
+func GetJobByObjectIDHex(hex string) (Job, error) {
+	var job Job
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return job, err
+	}
+	collection := DB.Database("websu").Collection("jobs")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&job)
+	return job, err
+}
+
+func (a *App) getJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	job, err := GetJobByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if job.Status == JobDone {
+		w.Header().Set("Location", "/scans/"+job.ScanID.Hex())
+	}
+	json.NewEncoder(w).Encode(&job)
+}
+
+// cancelJob cancels a queued or running job and its scan. Jobs that
+// already finished can't be cancelled. Queued jobs are cancelled right
+// away, running jobs record their cancellation once Lighthouse stopped.
+func (a *App) cancelJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	job, err := GetJobByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	// The job may start or finish at any time, so it is only cancelled if
+	// it is still queued when the cancellation is stored.
+	cancelled, err := job.setStatusFrom([]string{JobQueued}, JobCancelled, errCancelled)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if cancelled {
+		scan := &Scan{ID: job.ScanID}
+		if err := scan.setStatus(ScanCancelled, errCancelled); err != nil {
+			storeLog.Errorf("Marking scan %s as cancelled: %v", scan.ID.Hex(), err)
+		}
+	} else if !a.Workers.Cancel(job.ID) {
+		if job, err = GetJobByObjectIDHex(job.ID.Hex()); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		writeError(w, r, "Job "+job.ID.Hex()+" is already "+job.Status, http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&job)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultLeaderboardDays  = 7
+	defaultLeaderboardLimit = 10
+)
+
+// Leaderboard ranks URLs by how much a score or metric changed between
+// their first and last scan within the last Days days. Improved and
+// Regressed are sorted by the size of the change, largest first.
+type Leaderboard struct {
+	Metric    string             `json:"metric"`
+	Days      int                `json:"days"`
+	Improved  []LeaderboardEntry `json:"improved"`
+	Regressed []LeaderboardEntry `json:"regressed"`
+}
+
+type LeaderboardEntry struct {
+	URL    string  `json:"url"`
+	From   float64 `json:"from"`
+	To     float64 `json:"to"`
+	Change float64 `json:"change"`
+}
+
+// rank fills the leaderboard from scans sorted by creation time, keeping
+// at most limit entries per list.
+func (l *Leaderboard) rank(scans []Scan, limit int) {
+	first := make(map[string]float64)
+	last := make(map[string]float64)
+	urls := []string{}
+	for _, scan := range scans {
+		v := scan.Value(l.Metric)
+		if v == nil {
+			continue
+		}
+		if _, ok := first[scan.URL]; !ok {
+			first[scan.URL] = *v
+			urls = append(urls, scan.URL)
+		}
+		last[scan.URL] = *v
+	}
+	l.Improved, l.Regressed = []LeaderboardEntry{}, []LeaderboardEntry{}
+	for _, url := range urls {
+		e := LeaderboardEntry{URL: url, From: first[url], To: last[url], Change: last[url] - first[url]}
+		improvement := e.Change
+		if !higherIsBetter(l.Metric) {
+			improvement = -improvement
+		}
+		if improvement > 0 {
+			l.Improved = append(l.Improved, e)
+		} else if improvement < 0 {
+			l.Regressed = append(l.Regressed, e)
+		}
+	}
+	bySize := func(entries []LeaderboardEntry) {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return abs(entries[i].Change) > abs(entries[j].Change)
+		})
+	}
+	bySize(l.Improved)
+	bySize(l.Regressed)
+	if len(l.Improved) > limit {
+		l.Improved = l.Improved[:limit]
+	}
+	if len(l.Regressed) > limit {
+		l.Regressed = l.Regressed[:limit]
+	}
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+// getLeaderboard ranks the URLs with the biggest improvements and
+// regressions of ?metric over the last ?days days, 7 by default.
+func (a *App) getLeaderboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	l := Leaderboard{Metric: query.Get("metric"), Days: defaultLeaderboardDays}
+	if l.Metric == "" {
+		l.Metric = "performance"
+	}
+	known, err := isMetricName(l.Metric)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	} else if !known {
+		writeError(w, r, "Unknown metric "+l.Metric, http.StatusBadRequest)
+		return
+	}
+	if d := query.Get("days"); d != "" {
+		if l.Days, err = strconv.Atoi(d); err != nil || l.Days <= 0 {
+			writeError(w, r, "days must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultLeaderboardLimit
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxPageLimit {
+			writeError(w, r, "limit must be a number between 1 and "+strconv.Itoa(maxPageLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	filter := bson.M{
+		"status":     finishedScans,
+		"created_at": bson.M{"$gte": time.Now().AddDate(0, 0, -l.Days)},
+	}
+	scans, err := FindAnalyticsScans(QueryReports, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	l.rank(scans, limit)
+	json.NewEncoder(w).Encode(&l)
+}
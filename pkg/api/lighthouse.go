@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// LighthouseReport holds the parts of the Lighthouse JSON output that the
+// API reads.
+type LighthouseReport struct {
+	RequestedURL string `json:"requestedUrl"`
+	// MainDocumentURL replaces FinalURL in Lighthouse 10 and later.
+	MainDocumentURL string                        `json:"mainDocumentUrl"`
+	FinalURL        string                        `json:"finalUrl"`
+	Categories      map[string]LighthouseCategory `json:"categories"`
+	Audits          map[string]LighthouseAudit    `json:"audits"`
+	Environment     struct {
+		// BenchmarkIndex measures how fast the machine running Lighthouse
+		// is.
+		BenchmarkIndex *float64 `json:"benchmarkIndex"`
+	} `json:"environment"`
+}
+
+type LighthouseCategory struct {
+	ID        string               `json:"id"`
+	Title     string               `json:"title"`
+	Score     *float64             `json:"score"`
+	AuditRefs []LighthouseAuditRef `json:"auditRefs"`
+}
+
+// LighthouseAuditRef is an audit that counts towards a category.
+type LighthouseAuditRef struct {
+	ID string `json:"id"`
+}
+
+type LighthouseAudit struct {
+	ID               string          `json:"id"`
+	Title            string          `json:"title"`
+	Score            *float64        `json:"score"`
+	ScoreDisplayMode string          `json:"scoreDisplayMode"`
+	NumericValue     *float64        `json:"numericValue"`
+	DisplayValue     string          `json:"displayValue"`
+	Details          json.RawMessage `json:"details"`
+}
+
+// AuditValue returns the numeric value of an audit, e.g. the milliseconds
+// of a timing metric, or nil when the audit is missing or has no value.
+func (r *LighthouseReport) AuditValue(audit string) *float64 {
+	a, ok := r.Audits[audit]
+	if !ok {
+		return nil
+	}
+	return a.NumericValue
+}
+
+// auditItems decodes the details.items of an audit into items. It leaves
+// items untouched when the audit is missing or has no details.
+func (r *LighthouseReport) auditItems(audit string, items interface{}) error {
+	a, ok := r.Audits[audit]
+	if !ok || len(a.Details) == 0 {
+		return nil
+	}
+	var details struct {
+		Items json.RawMessage `json:"items"`
+	}
+	if err := json.Unmarshal(a.Details, &details); err != nil {
+		return err
+	}
+	if len(details.Items) == 0 {
+		return nil
+	}
+	return json.Unmarshal(details.Items, items)
+}
+
+// auditPassScore is the score from which Lighthouse shows an audit as
+// passed.
+const auditPassScore = 0.9
+
+// AuditResults returns whether each binary or numeric audit passed, keyed
+// by audit ID. Audits without a score are left out.
+func (r *LighthouseReport) AuditResults() map[string]bool {
+	results := make(map[string]bool)
+	for id, a := range r.Audits {
+		if a.Score == nil || (a.ScoreDisplayMode != "binary" && a.ScoreDisplayMode != "numeric") {
+			continue
+		}
+		results[id] = *a.Score >= auditPassScore
+	}
+	return results
+}
+
+func ParseLighthouseReport(data []byte) (*LighthouseReport, error) {
+	report := new(LighthouseReport)
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CategoryScore returns the score of the given category, or nil when the
+// category was not audited or could not be scored.
+func (r *LighthouseReport) CategoryScore(category string) *float64 {
+	c, ok := r.Categories[category]
+	if !ok {
+		return nil
+	}
+	return c.Score
+}
+
+// AuditedURL returns the URL Lighthouse ended up auditing after following
+// redirects.
+func (r *LighthouseReport) AuditedURL() string {
+	if r.MainDocumentURL != "" {
+		return r.MainDocumentURL
+	}
+	return r.FinalURL
+}
+
+// RedirectChain returns the URLs of the redirects Lighthouse followed, in
+// order, starting with the requested URL. It is empty when the requested
+// URL did not redirect.
+func (r *LighthouseReport) RedirectChain() ([]string, error) {
+	var items []struct {
+		URL string `json:"url"`
+	}
+	if err := r.auditItems("redirects", &items); err != nil {
+		return nil, err
+	}
+	chain := []string{}
+	for _, item := range items {
+		chain = append(chain, item.URL)
+	}
+	return chain, nil
+}
+
+// applyReport copies the data the API keeps about a scan out of its
+// Lighthouse report.
+func (scan *Scan) applyReport(report *LighthouseReport) error {
+	chain, err := report.RedirectChain()
+	if err != nil {
+		return err
+	}
+	scan.RedirectChain = chain
+	scan.FinalURL = report.AuditedURL()
+	scan.Scores = scoresFromReport(report)
+	scan.Metrics = metricsFromReport(report)
+	scan.AuditResults = report.AuditResults()
+	if scan.UserTimings, err = report.UserTimings(); err != nil {
+		return err
+	}
+	if scan.ThirdParties, err = report.ThirdParties(); err != nil {
+		return err
+	}
+	if scan.ResourceSizes, err = report.ResourceSizes(); err != nil {
+		return err
+	}
+	if scan.UnusedCode, err = report.BundleUsages(); err != nil {
+		return err
+	}
+	if scan.AccessibilityIssues, err = report.AccessibilityIssues(); err != nil {
+		return err
+	}
+	requested, err := url.Parse(scan.URL)
+	if err != nil {
+		return err
+	}
+	final, err := url.Parse(scan.FinalURL)
+	if err != nil {
+		return err
+	}
+	scan.HostChanged = scan.FinalURL != "" && !strings.EqualFold(requested.Hostname(), final.Hostname())
+	if scan.HostChanged {
+		engineLog.Warnf("Scan %s of %s was redirected to another host: %s",
+			scan.ID.Hex(), scan.URL, scan.FinalURL)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type LogLevel int32
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[LogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+func ParseLogLevel(s string) (LogLevel, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level %q, expected one of debug, info, warn, error", s)
+}
+
+// Logger prefixes messages with its component name and drops messages
+// below its level. The level can be changed at runtime.
+type Logger struct {
+	component string
+	level     int32
+}
+
+func NewLogger(component string) *Logger {
+	return &Logger{component: component, level: int32(LevelInfo)}
+}
+
+func (l *Logger) Level() LogLevel {
+	return LogLevel(atomic.LoadInt32(&l.level))
+}
+
+func (l *Logger) SetLevel(level LogLevel) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+func (l *Logger) logf(level LogLevel, format string, v ...interface{}) {
+	if level < l.Level() {
+		return
+	}
+	log.Printf("["+l.component+"] "+strings.ToUpper(level.String())+" "+format, v...)
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(LevelDebug, format, v...) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.logf(LevelInfo, format, v...) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.logf(LevelWarn, format, v...) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(LevelError, format, v...) }
+
+var (
+	httpLog   = NewLogger("http")
+	engineLog = NewLogger("engine")
+	storeLog  = NewLogger("store")
+
+	// Loggers holds every component logger by name so levels can be
+	// adjusted through the admin API.
+	Loggers = map[string]*Logger{
+		"http":   httpLog,
+		"engine": engineLog,
+		"store":  storeLog,
+	}
+)
+
+// SetLogLevel sets the level of a single component, or of all components
+// when component is empty.
+func SetLogLevel(component string, level LogLevel) error {
+	if component == "" {
+		for _, l := range Loggers {
+			l.SetLevel(level)
+		}
+		return nil
+	}
+	l, ok := Loggers[component]
+	if !ok {
+		return fmt.Errorf("Unknown log component %q", component)
+	}
+	l.SetLevel(level)
+	return nil
+}
+
+func GetLogLevels() map[string]string {
+	levels := make(map[string]string)
+	for name, l := range Loggers {
+		levels[name] = l.Level().String()
+	}
+	return levels
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// mutableScanFields are the fields of a scan PATCH /scans/{id} can change.
+// Everything else, in particular the results and the reports, is kept as
+// the scan recorded it.
+var mutableScanFields = map[string]bool{"label": true, "notes": true, "project": true, "tags": true}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) of the mutable fields to
+// scan. Fields set to null are removed, lists are replaced as a whole.
+func (scan *Scan) mergePatch(patch map[string]json.RawMessage) error {
+	for field, value := range patch {
+		if !mutableScanFields[field] {
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeImmutableField,
+				msg: "Field " + field + " of a scan can't be changed"}
+		}
+		if bytes.Equal(value, []byte("null")) {
+			value = nil
+		}
+		var err error
+		switch field {
+		case "label":
+			scan.Label = ""
+			if value != nil {
+				err = json.Unmarshal(value, &scan.Label)
+			}
+		case "notes":
+			scan.Notes = ""
+			if value != nil {
+				err = json.Unmarshal(value, &scan.Notes)
+			}
+		case "project":
+			scan.Project = nil
+			if value != nil {
+				err = json.Unmarshal(value, &scan.Project)
+			}
+		case "tags":
+			scan.Tags = nil
+			if value != nil {
+				err = json.Unmarshal(value, &scan.Tags)
+			}
+		}
+		if err != nil {
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeInvalidFieldValue,
+				msg: "Request body contains an invalid value for the " + field + " field"}
+		}
+	}
+	if err := validateTags(scan.Tags); err != nil {
+		return err
+	}
+	return validateProject(scan.Project)
+}
+
+// patchScan updates the metadata of a finished scan with a JSON Merge
+// Patch, see Scan.mergePatch.
+func (a *App) patchScan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, _ := mime.ParseMediaType(ct); mt != "application/merge-patch+json" && mt != "application/json" {
+			writeError(w, r, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	scan, err := GetScanByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var patch map[string]json.RawMessage
+	if err := decodeJSONBody(w, r, &patch); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	// A running scan is written as a whole once it finishes, which would
+	// undo the patch.
+	if !isFinished(scan.Status) {
+		writeError(w, r, "Scan "+scan.ID.Hex()+" is still "+scan.Status, http.StatusConflict)
+		return
+	}
+	if err := scan.mergePatch(patch); err != nil {
+		if _, ok := err.(*malformedRequest); ok {
+			writeDecodeError(w, r, err)
+		} else {
+			writeError(w, r, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if err := scan.Update(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&scan)
+}
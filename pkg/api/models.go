@@ -2,14 +2,12 @@ package api
 
 import (
 	"context"
-	"errors"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
-	"path/filepath"
 	"time"
 )
 
@@ -29,27 +27,117 @@ func CreateMongoClient(mongoURI string) {
 	}
 }
 
+const (
+	ScanPending   = "pending"
+	ScanRunning   = "running"
+	ScanSucceeded = "succeeded"
+	ScanFailed    = "failed"
+	ScanCancelled = "cancelled"
+	ScanTimedOut  = "timed_out"
+)
+
+const errCancelled = "Scan was cancelled"
+
+// finishedScans matches scans that are not pending or running, including
+// scans stored before scans had a status. scoredScans further leaves out
+// scans that failed, were cancelled or timed out and thus have no results.
+var (
+	finishedScans = bson.M{"$nin": []string{ScanPending, ScanRunning}}
+	scoredScans   = bson.M{"$nin": []string{ScanPending, ScanRunning, ScanFailed, ScanCancelled, ScanTimedOut}}
+)
+
 type Scan struct {
 	ID           primitive.ObjectID `json:"id" bson:"_id"`
 	URL          string             `json:"url" bson:"url"`
 	JsonLocation string             `json:"jsonLocation" bson:"jsonLocation"`
-	Json         string             `json:"json" bson:"-"`
+	HtmlLocation string             `json:"htmlLocation,omitempty" bson:"htmlLocation,omitempty"`
+	Json         string             `json:"json,omitempty" bson:"-"`
+	Html         string             `json:"html,omitempty" bson:"-"`
 	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	Status       string             `json:"status,omitempty" bson:"status,omitempty"`
+	// Error explains why a failed scan failed, including the end of the
+	// Lighthouse error output.
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// ChromeProfile selects how the Chrome user data dir is handled, see
+	// ChromeProfileFresh and ChromeProfilePersistent. When empty a pooled
+	// Chrome instance is used if available.
+	ChromeProfile string `json:"chrome_profile,omitempty" bson:"chrome_profile,omitempty"`
+	// ProtocolPresets are names of protocolPresets applied to Chrome.
+	ProtocolPresets []string `json:"protocol_presets,omitempty" bson:"protocol_presets,omitempty"`
+	// HostOverrides maps host names to the IP address Chrome resolves them
+	// to, e.g. to point a pre-production host at a canary load balancer.
+	HostOverrides map[string]string `json:"host_overrides,omitempty" bson:"host_overrides,omitempty"`
+	// Project is the ID of the project the scan belongs to, if any. Tags
+	// are free-form labels to filter scans by.
+	Project *primitive.ObjectID `json:"project,omitempty" bson:"project,omitempty"`
+	Tags    []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Label and Notes describe the scan, e.g. the release it was run for.
+	Label string `json:"label,omitempty" bson:"label,omitempty"`
+	Notes string `json:"notes,omitempty" bson:"notes,omitempty"`
+	// TimeoutSeconds overrides SCAN_TIMEOUT for the scan.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty" bson:"timeout_seconds,omitempty"`
+	// RunnerType is the ScanRunner the scan runs with, see scanRunners.
+	// When empty SCAN_RUNNER applies.
+	RunnerType string `json:"runner_type,omitempty" bson:"runner_type,omitempty"`
+	// Runner is the machine the scan ran on. It is only recorded for scans
+	// run by the local Lighthouse CLI.
+	Runner *Runner `json:"runner,omitempty" bson:"runner,omitempty"`
+	// CPUSlowdown is the CPU throttling multiplier the scan ran with when it
+	// was calibrated to the runner, see THROTTLING_CALIBRATION.
+	CPUSlowdown *float64 `json:"cpu_slowdown,omitempty" bson:"cpu_slowdown,omitempty"`
+	// Options customize the Lighthouse run.
+	Options *LighthouseOptions `json:"options,omitempty" bson:"options,omitempty"`
+	// TargetAuth only lives in memory for the duration of the scan. What
+	// gets stored is the encrypted EncryptedTargetAuth.
+	TargetAuth          *TargetAuth `json:"target_auth,omitempty" bson:"-"`
+	EncryptedTargetAuth string      `json:"-" bson:"target_auth,omitempty"`
+	// FinalURL is the URL that was audited after following RedirectChain.
+	FinalURL      string   `json:"final_url,omitempty" bson:"final_url,omitempty"`
+	RedirectChain []string `json:"redirect_chain,omitempty" bson:"redirect_chain,omitempty"`
+	// HostChanged is set when the scan was redirected to another host,
+	// which usually points at a misconfigured redirect or parked domain.
+	HostChanged bool `json:"host_changed,omitempty" bson:"host_changed,omitempty"`
+	// ScreenshotLocation is where the final screenshot of the page is
+	// stored and ScreenshotHash is its perceptual hash, see perceptualHash.
+	ScreenshotLocation string `json:"screenshotLocation,omitempty" bson:"screenshotLocation,omitempty"`
+	ScreenshotHash     string `json:"screenshot_hash,omitempty" bson:"screenshot_hash,omitempty"`
+	// ReportPurgedAt is set once the report was removed by the retention
+	// policy. The rest of the scan is kept until it expires as well.
+	ReportPurgedAt *time.Time `json:"report_purged_at,omitempty" bson:"report_purged_at,omitempty"`
+	Scores         *Scores    `json:"scores,omitempty" bson:"scores,omitempty"`
+	Metrics        *Metrics   `json:"metrics,omitempty" bson:"metrics,omitempty"`
+	// CustomMetrics holds the values of the custom metrics defined with
+	// MetricDefinition when the scan was ingested.
+	CustomMetrics map[string]float64 `json:"custom_metrics,omitempty" bson:"custom_metrics,omitempty"`
+	// UserTimings are the marks and measures the page recorded with the
+	// User Timing API.
+	UserTimings []UserTiming `json:"user_timings,omitempty" bson:"user_timings,omitempty"`
+	// ThirdParties breaks down the cost of the third-party vendors the page
+	// loaded.
+	ThirdParties []ThirdPartyImpact `json:"third_parties,omitempty" bson:"third_parties,omitempty"`
+	// ResourceSizes holds the transferred bytes per resource type, e.g.
+	// script or image, see resourceTypes.
+	ResourceSizes map[string]float64 `json:"resource_sizes,omitempty" bson:"resource_sizes,omitempty"`
+	// UnusedCode lists the unused bytes of the scripts and stylesheets.
+	UnusedCode []BundleUsage `json:"unused_code,omitempty" bson:"unused_code,omitempty"`
+	// AccessibilityIssues lists the elements failing accessibility audits.
+	AccessibilityIssues []AccessibilityIssue `json:"accessibility_issues,omitempty" bson:"accessibility_issues,omitempty"`
+	// AuditResults records which scored audits passed, keyed by audit ID.
+	// It is only used to track flaky audits and is not returned by the API.
+	AuditResults map[string]bool `json:"-" bson:"audit_results,omitempty"`
 }
 
 func GetAllScans() ([]Scan, error) {
-	scans := []Scan{}
-	collection := DB.Database("websu").Collection("scans")
-	c := context.TODO()
-	cursor, err := collection.Find(c, bson.D{})
-	if err != nil {
-		return nil, err
-	}
-	if err := cursor.All(c, &scans); err != nil {
-		return nil, err
-	}
-	return scans, nil
+	return Scans.List(ScanQuery{})
+}
 
+// FindScans queries the Mongo scans collection directly, for the reporting
+// endpoints that filter on more than ScanQuery supports.
+func FindScans(filter interface{}, opts *options.FindOptions) ([]Scan, error) {
+	if !scansInMongo() {
+		return nil, ErrScansNotInMongo
+	}
+	return findScans(DB.Database("websu").Collection("scans"), filter, opts)
 }
 
 func NewScan() *Scan {
@@ -60,12 +148,25 @@ func NewScan() *Scan {
 }
 
 func (scan *Scan) Insert() error {
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	collection := DB.Database("websu").Collection("scans")
-	log.Printf("Inserting Scan: %+v", scan)
-	if _, err := collection.InsertOne(ctx, scan); err != nil {
+	storeLog.Debugf("Inserting Scan: %+v", scan)
+	return Scans.Create(scan)
+}
+
+// Update replaces the stored scan with scan.
+func (scan *Scan) Update() error {
+	storeLog.Debugf("Updating Scan: %+v", scan)
+	return Scans.Update(scan)
+}
+
+func (scan *Scan) setStatus(status string, errMsg string) error {
+	if err := Scans.SetStatus(scan.ID, status, errMsg); err != nil {
 		return err
 	}
+	scan.Status = status
+	if errMsg != "" {
+		scan.Error = errMsg
+	}
+	scanEvents.publishStatus(scan)
 	return nil
 }
 
@@ -73,36 +174,60 @@ func (scan *Scan) Delete() error {
 	ctx := context.Background()
 	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
-	log.Printf("Deleting GCS object of scan: %+v", scan)
-	o := gcsClient.Bucket(Bucket).Object(filepath.Base(scan.JsonLocation))
-	if err := o.Delete(ctx); err != nil {
-		return err
+	if scan.JsonLocation != "" {
+		storeLog.Debugf("Deleting reports of scan: %+v", scan)
 	}
-	result, err := DB.Database("websu").Collection("scans").DeleteOne(context.TODO(), bson.M{"_id": scan.ID}, nil)
-	if err != nil {
+	if err := scan.deleteReports(ctx); err != nil {
 		return err
 	}
-	if result.DeletedCount == 1 {
-		return nil
-	} else if result.DeletedCount == 0 {
-		return errors.New("Scan with id " + scan.ID.Hex() + " did not exist")
-	} else {
-		return errors.New("Multiple scans were deleted.")
+	return Scans.Delete(scan.ID)
+}
+
+// deleteReports deletes the stored reports and screenshot of the scan.
+// Reports that are already gone, e.g. removed by a bucket lifecycle rule,
+// count as deleted.
+func (scan *Scan) deleteReports(ctx context.Context) error {
+	for _, location := range []string{scan.JsonLocation, scan.HtmlLocation, scan.ScreenshotLocation} {
+		if location == "" {
+			continue
+		}
+		if err := deleteReport(ctx, location); err != nil && err != ErrReportNotFound {
+			return err
+		}
 	}
 	return nil
 }
 
-func GetScanByObjectIDHex(hex string) (Scan, error) {
-	var scan Scan
-	collection := DB.Database("websu").Collection("scans")
-	oid, err := primitive.ObjectIDFromHex(hex)
-	if err != nil {
-		return scan, err
+func GetLatestScanByURL(url string) (Scan, error) {
+	return Scans.Latest(url)
+}
+
+// PurgeReport deletes the stored Lighthouse report of the scan but keeps
+// the scan itself.
+func (scan *Scan) PurgeReport() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := scan.deleteReports(ctx); err != nil {
+		return err
 	}
-	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&scan)
+	now := time.Now()
+	scan.ReportPurgedAt = &now
+	return scan.unsetReport()
+}
+
+// unsetReport removes the references to the reports of the scan. The
+// screenshot hash is kept.
+func (scan *Scan) unsetReport() error {
+	scan.JsonLocation = ""
+	scan.HtmlLocation = ""
+	scan.ScreenshotLocation = ""
+	return Scans.Update(scan)
+}
+
+func GetScanByObjectIDHex(hex string) (Scan, error) {
+	oid, err := parseObjectID(hex)
 	if err != nil {
-		return scan, err
+		return Scan{}, err
 	}
-	return scan, nil
-
+	return Scans.Get(oid)
 }
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	FormFactorMobile  = "mobile"
+	FormFactorDesktop = "desktop"
+)
+
+// LighthouseOptions customize how Lighthouse runs a scan. Empty fields keep
+// the Lighthouse defaults.
+type LighthouseOptions struct {
+	// Categories limits the audited categories, e.g. performance and seo.
+	Categories []string `json:"categories,omitempty" bson:"categories,omitempty"`
+	// FormFactor is mobile or desktop. Desktop applies the Lighthouse
+	// desktop preset including its throttling.
+	FormFactor string `json:"form_factor,omitempty" bson:"form_factor,omitempty"`
+	// Throttling is the throttling method: simulate, devtools or provided
+	// to disable throttling.
+	Throttling string `json:"throttling,omitempty" bson:"throttling,omitempty"`
+	// Locale is the locale of the report, e.g. de.
+	Locale string `json:"locale,omitempty" bson:"locale,omitempty"`
+	// ChromeFlags are passed to Chrome in addition to the flags the scan
+	// needs anyway. Scans with Chrome flags don't use the Chrome pool.
+	ChromeFlags []string `json:"chrome_flags,omitempty" bson:"chrome_flags,omitempty"`
+}
+
+var (
+	throttlingMethods = map[string]bool{"simulate": true, "devtools": true, "provided": true}
+	localePattern     = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+	chromeFlagPattern = regexp.MustCompile(`^--[A-Za-z0-9-]+(=[^\s"']*)?$`)
+	// allowedChromeFlags are the Chrome flags scans can set. They only change
+	// how Chrome renders and loads the page. Flags for proxies, host rules,
+	// profiles and the like would let scans reach networks the target checks
+	// keep them from, so any other flag is refused.
+	allowedChromeFlags = map[string]bool{
+		"--lang": true, "--window-size": true, "--force-device-scale-factor": true,
+		"--force-dark-mode": true, "--blink-settings": true,
+		"--enable-features": true, "--disable-features": true,
+		"--enable-blink-features": true, "--disable-blink-features": true,
+		"--disable-gpu": true, "--disable-extensions": true, "--mute-audio": true,
+		"--enable-quic": true, "--disable-quic": true, "--disable-http2": true,
+	}
+)
+
+func (o *LighthouseOptions) validate() error {
+	if o == nil {
+		return nil
+	}
+	for _, category := range o.Categories {
+		if !categoryNames[category] {
+			return errors.New("Unknown category " + category +
+				", expected performance, accessibility, best-practices, seo or pwa")
+		}
+	}
+	switch o.FormFactor {
+	case "", FormFactorMobile, FormFactorDesktop:
+	default:
+		return errors.New("Unknown form_factor " + o.FormFactor + ", expected mobile or desktop")
+	}
+	if o.Throttling != "" && !throttlingMethods[o.Throttling] {
+		return errors.New("Unknown throttling " + o.Throttling + ", expected simulate, devtools or provided")
+	}
+	if o.Locale != "" && !localePattern.MatchString(o.Locale) {
+		return errors.New("Locale " + o.Locale + " is not a valid locale")
+	}
+	for _, flag := range o.ChromeFlags {
+		if !chromeFlagPattern.MatchString(flag) {
+			return errors.New("Chrome flag " + flag + " is not a valid flag")
+		}
+		if !allowedChromeFlags[strings.SplitN(flag, "=", 2)[0]] {
+			return errors.New("Chrome flag " + flag + " can't be set")
+		}
+	}
+	return nil
+}
+
+// args returns the Lighthouse flags implementing the options.
+func (o *LighthouseOptions) args() []string {
+	if o == nil {
+		return nil
+	}
+	args := []string{}
+	if len(o.Categories) > 0 {
+		args = append(args, "--only-categories="+strings.Join(o.Categories, ","))
+	}
+	switch o.FormFactor {
+	case FormFactorDesktop:
+		args = append(args, "--preset=desktop")
+	case FormFactorMobile:
+		args = append(args, "--form-factor=mobile")
+	}
+	if o.Throttling != "" {
+		args = append(args, "--throttling-method="+o.Throttling)
+	}
+	if o.Locale != "" {
+		args = append(args, "--locale="+o.Locale)
+	}
+	return args
+}
@@ -0,0 +1,19 @@
+package api
+
+import "testing"
+
+func TestValidateChromeFlags(t *testing.T) {
+	for _, flag := range []string{"--lang=de", "--disable-gpu", "--window-size=1280,800"} {
+		if err := (&LighthouseOptions{ChromeFlags: []string{flag}}).validate(); err != nil {
+			t.Errorf("Expected %s to be allowed. Got %v", flag, err)
+		}
+	}
+	for _, flag := range []string{"--user-data-dir=/tmp", "--remote-debugging-port=9222",
+		"--host-resolver-rules=MAP * 10.0.0.5", "--host-rules=MAP * 10.0.0.5",
+		"--proxy-server=http://10.0.0.5:3128", "--proxy-pac-url=http://10.0.0.5/proxy.pac",
+		"--proxy-bypass-list=*", "--no-proxy-server"} {
+		if err := (&LighthouseOptions{ChromeFlags: []string{flag}}).validate(); err == nil {
+			t.Errorf("Expected %s to be refused", flag)
+		}
+	}
+}
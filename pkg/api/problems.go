@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Problem is the RFC 7807 body of every error response. Code identifies
+// the error for clients, Detail explains it to humans.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// Error codes that are not derived from the status of the response.
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidFieldValue  = "invalid_field_value"
+	CodeUnknownField       = "unknown_field"
+	CodeEmptyBody          = "empty_body"
+	CodeBodyTooLarge       = "body_too_large"
+	CodeMultipleJSONValues = "multiple_json_values"
+	CodeRateLimited        = "rate_limited"
+	CodeInvalidID          = "invalid_id"
+	CodeStoreUnavailable   = "store_unavailable"
+	CodeReportStoreError   = "report_store_error"
+	CodeImmutableField     = "immutable_field"
+	CodeNotImplemented     = "not_implemented"
+	CodeAlreadyExists      = "already_exists"
+)
+
+// statusCodes are the error codes of responses that have no more specific
+// code.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// writeError responds with a problem of status, like http.Error does with
+// plain text.
+func writeError(w http.ResponseWriter, r *http.Request, detail string, status int) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = "error"
+	}
+	writeProblem(w, r, status, code, detail)
+}
+
+// notFoundError reports that a document did not exist.
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+func errNotFound(kind string, id primitive.ObjectID) error {
+	return &notFoundError{kind + " with id " + id.Hex() + " did not exist"}
+}
+
+// alreadyExistsError reports that a document with the same unique name was
+// stored before.
+type alreadyExistsError struct {
+	msg string
+}
+
+func (e *alreadyExistsError) Error() string {
+	return e.msg
+}
+
+// invalidIDError reports a malformed id in a request.
+type invalidIDError struct {
+	id string
+}
+
+func (e *invalidIDError) Error() string {
+	return e.id + " is not a valid id"
+}
+
+// parseObjectID parses the hex id of a document given in a request.
+func parseObjectID(hex string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return oid, &invalidIDError{hex}
+	}
+	return oid, nil
+}
+
+// storeUnavailable reports whether err means the database could not be
+// reached, as opposed to rejecting the operation.
+func storeUnavailable(err error) bool {
+	var netErr net.Error
+	var cmdErr mongo.CommandError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, mongo.ErrClientDisconnected),
+		errors.Is(err, driver.ErrBadConn), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &cmdErr):
+		return cmdErr.HasErrorLabel("NetworkError")
+	}
+	// The Mongo driver doesn't wrap its server selection errors.
+	return strings.HasPrefix(err.Error(), "server selection error")
+}
+
+// writeStoreError responds to a failed database operation: 400 for
+// malformed ids, 404 when the document did not exist, 409 when it already
+// existed, 503 when the database can't be reached and 500 otherwise.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	var invalidID *invalidIDError
+	var notFound *notFoundError
+	var exists *alreadyExistsError
+	switch {
+	case errors.As(err, &invalidID):
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidID, err.Error())
+	case err == ErrScansNotInMongo:
+		writeProblem(w, r, http.StatusNotImplemented, CodeNotImplemented, err.Error())
+	case errors.Is(err, mongo.ErrNoDocuments):
+		writeError(w, r, "The requested document does not exist", http.StatusNotFound)
+	case errors.As(err, &notFound):
+		writeError(w, r, err.Error(), http.StatusNotFound)
+	case errors.As(err, &exists):
+		writeProblem(w, r, http.StatusConflict, CodeAlreadyExists, err.Error())
+	case storeUnavailable(err):
+		httpLog.Errorf("Database unavailable: %v", err)
+		writeProblem(w, r, http.StatusServiceUnavailable, CodeStoreUnavailable, "The database is unavailable, try again later")
+	default:
+		// The error of the driver describes the database, not the request.
+		httpLog.Errorf("Database operation failed: %v", err)
+		writeError(w, r, "The database operation failed", http.StatusInternalServerError)
+	}
+}
+
+// writeReportError responds to a failure reading a report from Reports,
+// whose backend is an upstream service.
+func writeReportError(w http.ResponseWriter, r *http.Request, scan *Scan, err error) {
+	if err == ErrReportNotFound {
+		writeError(w, r, "The report of scan "+scan.ID.Hex()+" is no longer available", http.StatusNotFound)
+		return
+	}
+	httpLog.Errorf("Reading the report of scan %s failed: %v", scan.ID.Hex(), err)
+	writeProblem(w, r, http.StatusBadGateway, CodeReportStoreError, "Reading the report failed: "+err.Error())
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     code,
+	})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseObjectID(t *testing.T) {
+	oid, err := parseObjectID("5f1a2b3c4d5e6f7a8b9c0d1e")
+	if err != nil || oid.Hex() != "5f1a2b3c4d5e6f7a8b9c0d1e" {
+		t.Errorf("Expected the id to parse. Got %v, %v", oid, err)
+	}
+	for _, hex := range []string{"", "nope", "5f1a2b3c4d5e6f7a8b9c0d1", "5f1a2b3c4d5e6f7a8b9c0d1z"} {
+		if _, err := parseObjectID(hex); err == nil {
+			t.Errorf("Expected %q to be rejected", hex)
+		} else if _, ok := err.(*invalidIDError); !ok {
+			t.Errorf("Expected an invalidIDError for %q. Got %T", hex, err)
+		}
+	}
+}
+
+func TestWriteStoreError(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+		code   string
+		detail string
+	}{
+		{&invalidIDError{"nope"}, http.StatusBadRequest, CodeInvalidID, "nope is not a valid id"},
+		{&alreadyExistsError{"Metric lcp-copy is already defined"}, http.StatusConflict, CodeAlreadyExists,
+			"Metric lcp-copy is already defined"},
+		{errors.New("(Unauthorized) command find requires authentication on websu.scans"),
+			http.StatusInternalServerError, "internal_error", "The database operation failed"},
+	} {
+		rr := httptest.NewRecorder()
+		writeStoreError(rr, httptest.NewRequest("GET", "/scans", nil), c.err)
+		var problem Problem
+		if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != c.status || problem.Code != c.code || problem.Detail != c.detail {
+			t.Errorf("Expected %d %s %q for %v. Got %d %s %q", c.status, c.code, c.detail, c.err,
+				rr.Code, problem.Code, problem.Detail)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package api
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// runProcessGroup runs cmd in its own process group and kills the whole
+// group when ctx is done, so Chrome launched by Lighthouse doesn't outlive
+// it.
+func runProcessGroup(ctx context.Context, cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
+}
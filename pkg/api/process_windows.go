@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"os/exec"
+)
+
+// runProcessGroup runs cmd and kills it when ctx is done. Unlike on other
+// platforms, processes started by cmd are not killed.
+func runProcessGroup(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+	return cmd.Wait()
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxTagLength = 64
+
+// Project organizes the scans of a team, e.g. the scans of the marketing
+// site apart from those of the app. Scans join a project with their
+// project field.
+type Project struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+func (p *Project) validate() error {
+	if p.Name == "" {
+		return errors.New("Project name must not be empty")
+	}
+	return nil
+}
+
+// validateTags checks that tags can be filtered on with ?tag.
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength || strings.ContainsAny(tag, ", ") {
+			return fmt.Errorf("Tag %q must be 1 to %d characters without commas or spaces", tag, maxTagLength)
+		}
+	}
+	return nil
+}
+
+// validateProject checks that the project a scan joins exists.
+func validateProject(id *primitive.ObjectID) error {
+	if id == nil {
+		return nil
+	}
+	if _, err := GetProject(*id); err == mongo.ErrNoDocuments {
+		return errors.New("Project " + id.Hex() + " does not exist")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetAllProjects() ([]Project, error) {
+	projects := []Project{}
+	collection := DB.Database("websu").Collection("projects")
+	c := context.TODO()
+	cursor, err := collection.Find(c, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+func GetProject(id primitive.ObjectID) (Project, error) {
+	var project Project
+	collection := DB.Database("websu").Collection("projects")
+	err := collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&project)
+	return project, err
+}
+
+func GetProjectByObjectIDHex(hex string) (Project, error) {
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return Project{}, err
+	}
+	return GetProject(oid)
+}
+
+func (p *Project) Insert() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("projects")
+	storeLog.Debugf("Inserting Project: %+v", p)
+	_, err := collection.InsertOne(ctx, p)
+	return err
+}
+
+func (p *Project) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("projects")
+	update := bson.M{"$set": bson.M{"name": p.Name, "description": p.Description}}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": p.ID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound("Project", p.ID)
+	}
+	return nil
+}
+
+func (p *Project) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("projects")
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": p.ID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound("Project", p.ID)
+	}
+	return nil
+}
+
+func (a *App) getProjects(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	projects, err := GetAllProjects()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&projects)
+}
+
+func (a *App) createProject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var project Project
+	if err := decodeJSONBody(w, r, &project); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := project.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	project.ID = primitive.NewObjectID()
+	project.CreatedAt = time.Now()
+	if err := project.Insert(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&project)
+}
+
+func (a *App) getProject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	project, err := GetProjectByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&project)
+}
+
+func (a *App) updateProject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	project, err := GetProjectByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var update Project
+	if err := decodeJSONBody(w, r, &update); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := update.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	project.Name = update.Name
+	project.Description = update.Description
+	if err := project.Update(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&project)
+}
+
+// deleteProject deletes a project that no scan belongs to anymore.
+func (a *App) deleteProject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	project, err := GetProjectByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	scans, err := Scans.Count(ScanQuery{Project: project.ID})
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if scans > 0 {
+		writeError(w, r, fmt.Sprintf("Project %s still has %d scans", project.ID.Hex(), scans), http.StatusConflict)
+		return
+	}
+	if err := project.Delete(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&Project{})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultPWADays = 30
+
+// pwaAudits are the audits deciding whether a page can be installed as a
+// Progressive Web App. Lighthouse 12 dropped service-worker, which makes
+// installable-manifest the only requirement there.
+var pwaAudits = []string{"installable-manifest", "service-worker", "maskable-icon", "splash-screen",
+	"themed-omnibox"}
+
+// installable reports whether the page of the scan could be installed, or
+// nil for scans without the installable-manifest audit.
+func (scan *Scan) installable() *bool {
+	manifest, ok := scan.AuditResults["installable-manifest"]
+	if !ok {
+		return nil
+	}
+	worker, ok := scan.AuditResults["service-worker"]
+	installable := manifest && (worker || !ok)
+	return &installable
+}
+
+// PWATrend is the series of the install readiness of a URL, one point per
+// scan, oldest first.
+type PWATrend struct {
+	URL    string     `json:"url"`
+	Days   int        `json:"days"`
+	Points []PWAPoint `json:"points"`
+}
+
+// PWAPoint holds the PWA score of a scan, whether the page was installable
+// and the results of the pwaAudits it ran.
+type PWAPoint struct {
+	ScanID      primitive.ObjectID `json:"scan_id"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Score       *float64           `json:"score"`
+	Installable *bool              `json:"installable"`
+	Audits      map[string]bool    `json:"audits"`
+}
+
+func pwaPoint(scan *Scan) PWAPoint {
+	p := PWAPoint{ScanID: scan.ID, CreatedAt: scan.CreatedAt, Installable: scan.installable(),
+		Audits: make(map[string]bool)}
+	if scan.Scores != nil {
+		p.Score = scan.Scores.PWA
+	}
+	for _, audit := range pwaAudits {
+		if passed, ok := scan.AuditResults[audit]; ok {
+			p.Audits[audit] = passed
+		}
+	}
+	return p
+}
+
+// previousScan returns the newest successful scan of the URL of scan that
+// was created before it, or nil when there is none.
+func previousScan(scan *Scan) (*Scan, error) {
+	scans, err := Scans.List(ScanQuery{
+		URL:           scan.URL,
+		Status:        ScanSucceeded,
+		CreatedBefore: scan.CreatedAt,
+		Sort:          bson.D{{Key: "created_at", Value: -1}},
+		Limit:         1,
+	})
+	if err != nil || len(scans) == 0 {
+		return nil, err
+	}
+	return &scans[0], nil
+}
+
+// installabilityChanged reports whether the page of scan became
+// installable or stopped being installable since the previous scan of its
+// URL.
+func installabilityChanged(scan *Scan) (bool, error) {
+	now := scan.installable()
+	if scan.Status != ScanSucceeded || now == nil {
+		return false, nil
+	}
+	previous, err := previousScan(scan)
+	if err != nil || previous == nil {
+		return false, err
+	}
+	before := previous.installable()
+	return before != nil && *before != *now, nil
+}
+
+// getPWATrend returns the install readiness of ?url over the last ?days
+// days, 30 by default.
+func (a *App) getPWATrend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	days, err := parseTrendQuery(r, defaultPWADays, "url")
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	trend := PWATrend{URL: r.URL.Query().Get("url"), Days: days}
+	scans, err := findTrendScans(QueryTrends, bson.M{"url": trend.URL, "status": scoredScans}, days)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	trend.Points = []PWAPoint{}
+	for i := range scans {
+		trend.Points = append(trend.Points, pwaPoint(&scans[i]))
+	}
+	json.NewEncoder(w).Encode(&trend)
+}
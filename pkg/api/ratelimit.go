@@ -0,0 +1,141 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader identifies a client for rate limiting. Clients without a
+// known key are limited by IP address.
+const APIKeyHeader = "X-API-Key"
+
+// unlimitedPaths are not rate limited, so probes keep working for busy
+// clients sharing an IP address.
+var unlimitedPaths = map[string]bool{"/healthz": true, "/readyz": true}
+
+// RateLimiter is a token bucket per client. Every client may make burst
+// requests at once and gets rate new tokens per second.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	// keys are the API keys that get a bucket of their own.
+	keys      map[string]bool
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// CreateRateLimiter limits every client to RATE_LIMIT_PER_MINUTE requests
+// per minute with bursts of up to RATE_LIMIT_BURST requests, which
+// defaults to the per minute limit. Only the comma separated API_KEYS
+// identify clients by key. Rate limiting is disabled when
+// RATE_LIMIT_PER_MINUTE is unset or 0.
+func CreateRateLimiter() *RateLimiter {
+	perMinute, _ := strconv.Atoi(os.Getenv("RATE_LIMIT_PER_MINUTE"))
+	if perMinute <= 0 {
+		return nil
+	}
+	burst, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST"))
+	if err != nil || burst <= 0 {
+		burst = perMinute
+	}
+	keys := []string{}
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return NewRateLimiter(float64(perMinute)/60, burst, keys...)
+}
+
+func NewRateLimiter(rate float64, burst int, keys ...string) *RateLimiter {
+	l := &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		keys:      make(map[string]bool),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	for _, key := range keys {
+		l.keys[key] = true
+	}
+	return l
+}
+
+// allow takes a token from the bucket of client. When the bucket is empty
+// it returns false and how long until the next token is available.
+func (l *RateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep forgets clients whose bucket has filled up again, as they are no
+// different from new clients.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// clientKey identifies the client of r by its API key or IP address.
+// Unknown keys are ignored, otherwise a client could get a fresh bucket
+// with every request by sending a new key.
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); l.keys[key] {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Middleware rejects requests of clients that exceeded their rate with 429
+// Too Many Requests and a Retry-After header in seconds.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unlimitedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := l.allow(l.clientKey(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeProblem(w, r, http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Query classes that can be routed to other members of the replica set
+// than the primary with READ_PREFERENCES.
+const (
+	// QueryTrends are the per-URL trend and forecast queries.
+	QueryTrends = "trends"
+	// QueryReports are the reports aggregating all URLs, e.g. the
+	// leaderboard and the feeds.
+	QueryReports = "reports"
+	// QueryAdmin are the queries of POST /admin/query.
+	QueryAdmin = "admin"
+)
+
+// readPreferences maps query classes to their read preference. Classes
+// that are missing read from the primary.
+var readPreferences = map[string]*readpref.ReadPref{}
+
+// CreateReadPreferences reads READ_PREFERENCES, a comma separated list of
+// query classes and read preference modes, e.g.
+// trends=secondaryPreferred,reports=secondary.
+func CreateReadPreferences() {
+	prefs, err := parseReadPreferences(os.Getenv("READ_PREFERENCES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	readPreferences = prefs
+}
+
+func parseReadPreferences(s string) (map[string]*readpref.ReadPref, error) {
+	prefs := make(map[string]*readpref.ReadPref)
+	if s == "" {
+		return prefs, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("READ_PREFERENCES entry %q must look like class=mode", entry)
+		}
+		switch kv[0] {
+		case QueryTrends, QueryReports, QueryAdmin:
+		default:
+			return nil, fmt.Errorf("Unknown query class %s in READ_PREFERENCES, expected trends, reports or admin", kv[0])
+		}
+		mode, err := readpref.ModeFromString(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		if prefs[kv[0]], err = readpref.New(mode); err != nil {
+			return nil, err
+		}
+	}
+	return prefs, nil
+}
+
+// scansCollectionFor returns the scans collection reading with the read
+// preference of the query class.
+func scansCollectionFor(class string) *mongo.Collection {
+	opts := options.Collection()
+	if pref, ok := readPreferences[class]; ok {
+		opts.SetReadPreference(pref)
+	}
+	return DB.Database("websu").Collection("scans", opts)
+}
+
+// FindAnalyticsScans is FindScans for the reporting endpoints, reading
+// with the read preference of the query class so that they don't load the
+// primary that ingests scans.
+func FindAnalyticsScans(class string, filter interface{}, opts *options.FindOptions) ([]Scan, error) {
+	if !scansInMongo() {
+		return nil, ErrScansNotInMongo
+	}
+	return findScans(scansCollectionFor(class), filter, opts)
+}
+
+// findTrendScans returns the scans matching filter that were created in
+// the last days days, oldest first. Only created_at and fields are loaded,
+// or all fields when none are given.
+func findTrendScans(class string, filter bson.M, days int, fields ...string) ([]Scan, error) {
+	filter["created_at"] = bson.M{"$gte": time.Now().AddDate(0, 0, -days)}
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	if len(fields) > 0 {
+		projection := bson.M{"created_at": 1}
+		for _, field := range fields {
+			projection[field] = 1
+		}
+		opts.SetProjection(projection)
+	}
+	return FindAnalyticsScans(class, filter, opts)
+}
+
+func findScans(collection *mongo.Collection, filter interface{}, opts *options.FindOptions) ([]Scan, error) {
+	scans := []Scan{}
+	c := context.TODO()
+	cursor, err := collection.Find(c, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &scans); err != nil {
+		return nil, err
+	}
+	return scans, nil
+}
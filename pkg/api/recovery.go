@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const errInterrupted = "Scan was interrupted by a restart"
+
+// Recovery records what happened to the unfinished jobs of the previous
+// run of the server when it started. Queued jobs are requeued, jobs that
+// were running when the server stopped are marked as interrupted.
+type Recovery struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Requeued    []Job              `json:"requeued" bson:"requeued"`
+	Interrupted []Job              `json:"interrupted" bson:"interrupted"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type poolContextKey struct{}
+
+// interrupted reports whether the job running with ctx was killed because
+// the server shut down, as opposed to being cancelled.
+func interrupted(ctx context.Context) bool {
+	poolCtx, ok := ctx.Value(poolContextKey{}).(context.Context)
+	return ok && poolCtx.Err() != nil
+}
+
+func findJobs(ctx context.Context, status string) ([]Job, error) {
+	collection := DB.Database("websu").Collection("jobs")
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := collection.Find(ctx, bson.M{"status": status}, opts)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	err = cursor.All(ctx, &jobs)
+	return jobs, err
+}
+
+// Recover picks up the jobs the previous run of the server left behind and
+// stores a Recovery when there were any. It must run before new scans are
+// accepted.
+func (p *WorkerPool) Recover() (*Recovery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	r := &Recovery{ID: primitive.NewObjectID(), Requeued: []Job{}, Interrupted: []Job{}, CreatedAt: time.Now()}
+	running, err := findJobs(ctx, JobRunning)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range running {
+		job.abortAs(JobInterrupted, &Scan{ID: job.ScanID}, errInterrupted)
+		r.Interrupted = append(r.Interrupted, job)
+	}
+	queued, err := findJobs(ctx, JobQueued)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range queued {
+		scan, err := Scans.Get(job.ScanID)
+		if err == nil {
+			err = scan.openTargetAuth()
+		}
+		if err != nil {
+			engineLog.Errorf("Requeuing job %s failed: %v", job.ID.Hex(), err)
+			job.abortAs(JobInterrupted, &Scan{ID: job.ScanID}, errInterrupted+": "+err.Error())
+			r.Interrupted = append(r.Interrupted, job)
+			continue
+		}
+		if err := p.requeue(job, &scan); err == ErrQueueFull {
+			r.Interrupted = append(r.Interrupted, job)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		r.Requeued = append(r.Requeued, job)
+	}
+	if len(r.Requeued) == 0 && len(r.Interrupted) == 0 {
+		return r, nil
+	}
+	engineLog.Infof("Requeued %d jobs and marked %d as interrupted", len(r.Requeued), len(r.Interrupted))
+	_, err = DB.Database("websu").Collection("recoveries").InsertOne(ctx, r)
+	return r, err
+}
+
+// getRecoveries lists the recoveries of the last ?limit server starts,
+// newest first.
+func (a *App) getRecoveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.M{"created_at": -1}).SetLimit(int64(limit))
+	cursor, err := DB.Database("websu").Collection("recoveries").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	recoveries := []Recovery{}
+	if err := cursor.All(ctx, &recoveries); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&recoveries)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// resourceTypes are the resource types of the Lighthouse resource-summary
+// audit. The transferred bytes of each type are available to Scan.Value as
+// <type>-bytes, e.g. script-bytes.
+var resourceTypes = []string{"document", "script", "stylesheet", "image", "font", "media", "other",
+	"third-party", "total"}
+
+func init() {
+	for _, t := range resourceTypes {
+		metricNames[t+"-bytes"] = true
+	}
+}
+
+// ResourceBudgetCheck compares the transferred bytes per resource type of
+// a scan with the budgets given in bytes.
+type ResourceBudgetCheck struct {
+	ScanID       primitive.ObjectID     `json:"scan_id"`
+	WithinBudget bool                   `json:"within_budget"`
+	Types        []ResourceBudgetResult `json:"types"`
+}
+
+// ResourceBudgetResult is over budget when the resource type exceeds its
+// budget. Bytes is null for scans stored before sizes were recorded.
+type ResourceBudgetResult struct {
+	Type       string   `json:"type"`
+	Bytes      *float64 `json:"bytes"`
+	Budget     float64  `json:"budget"`
+	OverBudget bool     `json:"over_budget"`
+}
+
+// ResourceSizes returns the transferred bytes per resource type of the
+// resource-summary audit.
+func (r *LighthouseReport) ResourceSizes() (map[string]float64, error) {
+	var items []struct {
+		ResourceType string  `json:"resourceType"`
+		TransferSize float64 `json:"transferSize"`
+	}
+	if err := r.auditItems("resource-summary", &items); err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]float64)
+	for _, item := range items {
+		sizes[item.ResourceType] = item.TransferSize
+	}
+	return sizes, nil
+}
+
+// getResourceBudget checks the scan against the budgets given as query
+// parameters named after resource types, e.g. ?script=170000&image=500000.
+func (a *App) getResourceBudget(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	scan, err := GetScanByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	check := ResourceBudgetCheck{ScanID: scan.ID, WithinBudget: true, Types: []ResourceBudgetResult{}}
+	for name, values := range r.URL.Query() {
+		if !metricNames[name+"-bytes"] {
+			writeError(w, r, "Unknown resource type "+name, http.StatusBadRequest)
+			return
+		}
+		budget, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || budget < 0 {
+			writeError(w, r, "Budget of "+name+" must be a positive number of bytes", http.StatusBadRequest)
+			return
+		}
+		result := ResourceBudgetResult{Type: name, Bytes: scan.Value(name + "-bytes"), Budget: budget}
+		result.OverBudget = result.Bytes != nil && *result.Bytes > budget
+		if result.OverBudget {
+			check.WithinBudget = false
+		}
+		check.Types = append(check.Types, result)
+	}
+	sort.Slice(check.Types, func(i, j int) bool { return check.Types[i].Type < check.Types[j].Type })
+	json.NewEncoder(w).Encode(&check)
+}
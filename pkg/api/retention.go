@@ -0,0 +1,87 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// retentionPageSize is how many expired scans are loaded at a time.
+const retentionPageSize = 100
+
+// Retention removes old data in two tiers. Full Lighthouse reports are
+// purged after ReportDays, while the scan documents, which keep the
+// compact summary of each scan, are deleted after ScanDays. A value of 0
+// keeps the data forever.
+type Retention struct {
+	ReportDays int
+	ScanDays   int
+}
+
+// CreateRetention configures retention from REPORT_RETENTION_DAYS and
+// SCAN_RETENTION_DAYS. It returns nil when neither is set.
+func CreateRetention() *Retention {
+	reportDays, _ := strconv.Atoi(os.Getenv("REPORT_RETENTION_DAYS"))
+	scanDays, _ := strconv.Atoi(os.Getenv("SCAN_RETENTION_DAYS"))
+	if reportDays <= 0 && scanDays <= 0 {
+		return nil
+	}
+	return &Retention{ReportDays: reportDays, ScanDays: scanDays}
+}
+
+// Run purges expired data every interval until the process exits.
+func (r *Retention) Run(interval time.Duration) {
+	for {
+		if err := r.Purge(time.Now()); err != nil {
+			storeLog.Errorf("Applying retention failed: %v", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (r *Retention) Purge(now time.Time) error {
+	if r.ReportDays > 0 {
+		cutoff := now.AddDate(0, 0, -r.ReportDays)
+		purged, err := forExpiredScans(ScanQuery{CreatedBefore: cutoff, HasReport: true}, (*Scan).PurgeReport)
+		storeLog.Infof("Purged %d reports created before %s", purged, cutoff)
+		if err != nil {
+			return err
+		}
+	}
+	if r.ScanDays > 0 {
+		cutoff := now.AddDate(0, 0, -r.ScanDays)
+		deleted, err := forExpiredScans(ScanQuery{CreatedBefore: cutoff}, (*Scan).Delete)
+		storeLog.Infof("Deleted %d scans created before %s", deleted, cutoff)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forExpiredScans applies action to the scans matching query, loading
+// retentionPageSize scans at a time. Scans action succeeded for no longer
+// match query, so every page starts at the first scan. It returns the
+// number of scans action succeeded for.
+func forExpiredScans(query ScanQuery, action func(*Scan) error) (int, error) {
+	query.Sort = bson.D{{Key: "created_at", Value: 1}}
+	query.Limit = retentionPageSize
+	done := 0
+	for {
+		scans, err := Scans.List(query)
+		if err != nil {
+			return done, err
+		}
+		for i := range scans {
+			if err := action(&scans[i]); err != nil {
+				return done, err
+			}
+			done++
+		}
+		if len(scans) < retentionPageSize {
+			return done, nil
+		}
+	}
+}
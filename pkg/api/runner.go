@@ -0,0 +1,233 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const defaultRunnerVarianceDays = 7
+
+// Runner describes the machine a scan ran on and how busy it was when the
+// scan started. LoadAverage and MemoryPressure are read from /proc and are
+// missing on other platforms.
+type Runner struct {
+	Hostname string `json:"hostname" bson:"hostname"`
+	CPUModel string `json:"cpu_model,omitempty" bson:"cpu_model,omitempty"`
+	CPUs     int    `json:"cpus" bson:"cpus"`
+	// LoadAverage is the 1 minute load average.
+	LoadAverage *float64 `json:"load_average,omitempty" bson:"load_average,omitempty"`
+	// MemoryPressure is the share of memory that is not available, between
+	// 0 and 1.
+	MemoryPressure *float64 `json:"memory_pressure,omitempty" bson:"memory_pressure,omitempty"`
+	// BenchmarkIndex is the speed of the runner Lighthouse measured.
+	BenchmarkIndex *float64 `json:"benchmark_index,omitempty" bson:"benchmark_index,omitempty"`
+}
+
+// currentRunner fingerprints the machine the API runs on.
+func currentRunner() *Runner {
+	hostname, _ := os.Hostname()
+	r := &Runner{Hostname: hostname, CPUs: runtime.NumCPU(), CPUModel: cpuModel()}
+	if data, err := ioutil.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			if load, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				r.LoadAverage = &load
+			}
+		}
+	}
+	if info := meminfo(); info["MemTotal"] > 0 {
+		pressure := 1 - info["MemAvailable"]/info["MemTotal"]
+		r.MemoryPressure = &pressure
+	}
+	return r
+}
+
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if kv := strings.SplitN(scanner.Text(), ":", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "model name" {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// meminfo returns the values of /proc/meminfo in kB.
+func meminfo() map[string]float64 {
+	info := make(map[string]float64)
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return info
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			info[strings.TrimSuffix(fields[0], ":")] = v
+		}
+	}
+	return info
+}
+
+// RunnerVariance relates the noise of a score or metric to the runner
+// conditions its scans ran under. The noise of a scan is how far its value
+// is from the mean of all scans of its URL within the last Days days.
+type RunnerVariance struct {
+	Metric  string        `json:"metric"`
+	Days    int           `json:"days"`
+	Runners []RunnerNoise `json:"runners"`
+	// LoadCorrelation and MemoryCorrelation are the Pearson correlation of
+	// the noise with the load average and memory pressure. They are null
+	// when there are too few scans to correlate.
+	LoadCorrelation   *float64 `json:"load_correlation"`
+	MemoryCorrelation *float64 `json:"memory_correlation"`
+}
+
+// RunnerNoise is the mean noise of the scans that ran on a runner.
+type RunnerNoise struct {
+	Hostname           string   `json:"hostname"`
+	Samples            int      `json:"samples"`
+	MeanDeviation      float64  `json:"mean_deviation"`
+	MeanLoadAverage    *float64 `json:"mean_load_average"`
+	MeanMemoryPressure *float64 `json:"mean_memory_pressure"`
+}
+
+// correlate fills the variance report from scans that recorded a runner.
+func (v *RunnerVariance) correlate(scans []Scan) {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, scan := range scans {
+		if value := scan.Value(v.Metric); value != nil && scan.Runner != nil {
+			sums[scan.URL] += *value
+			counts[scan.URL]++
+		}
+	}
+	type sample struct{ deviation, load, memory []float64 }
+	byHost := make(map[string]*sample)
+	loads, loadDeviations := []float64{}, []float64{}
+	memory, memoryDeviations := []float64{}, []float64{}
+	for _, scan := range scans {
+		value := scan.Value(v.Metric)
+		if value == nil || scan.Runner == nil {
+			continue
+		}
+		deviation := math.Abs(*value - sums[scan.URL]/float64(counts[scan.URL]))
+		s, ok := byHost[scan.Runner.Hostname]
+		if !ok {
+			s = &sample{}
+			byHost[scan.Runner.Hostname] = s
+		}
+		s.deviation = append(s.deviation, deviation)
+		if l := scan.Runner.LoadAverage; l != nil {
+			s.load = append(s.load, *l)
+			loads, loadDeviations = append(loads, *l), append(loadDeviations, deviation)
+		}
+		if m := scan.Runner.MemoryPressure; m != nil {
+			s.memory = append(s.memory, *m)
+			memory, memoryDeviations = append(memory, *m), append(memoryDeviations, deviation)
+		}
+	}
+	v.Runners = []RunnerNoise{}
+	for host, s := range byHost {
+		v.Runners = append(v.Runners, RunnerNoise{
+			Hostname:           host,
+			Samples:            len(s.deviation),
+			MeanDeviation:      *mean(s.deviation),
+			MeanLoadAverage:    mean(s.load),
+			MeanMemoryPressure: mean(s.memory),
+		})
+	}
+	sort.Slice(v.Runners, func(i, j int) bool {
+		return v.Runners[i].MeanDeviation > v.Runners[j].MeanDeviation
+	})
+	v.LoadCorrelation = pearson(loads, loadDeviations)
+	v.MemoryCorrelation = pearson(memory, memoryDeviations)
+}
+
+func mean(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	m := sum / float64(len(values))
+	return &m
+}
+
+// pearson returns the correlation coefficient of xs and ys, or nil when
+// there are fewer than 3 samples or either of them doesn't vary.
+func pearson(xs, ys []float64) *float64 {
+	if len(xs) < 3 {
+		return nil
+	}
+	mx, my := *mean(xs), *mean(ys)
+	var cov, vx, vy float64
+	for i := range xs {
+		dx, dy := xs[i]-mx, ys[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return nil
+	}
+	r := cov / math.Sqrt(vx*vy)
+	return &r
+}
+
+// getRunnerVariance reports how the noise of ?metric over the last ?days
+// days, 7 by default, relates to the runners the scans ran on.
+func (a *App) getRunnerVariance(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	v := RunnerVariance{Metric: query.Get("metric"), Days: defaultRunnerVarianceDays}
+	if v.Metric == "" {
+		v.Metric = "performance"
+	}
+	known, err := isMetricName(v.Metric)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	} else if !known {
+		writeError(w, r, "Unknown metric "+v.Metric, http.StatusBadRequest)
+		return
+	}
+	if d := query.Get("days"); d != "" {
+		if v.Days, err = strconv.Atoi(d); err != nil || v.Days <= 0 {
+			writeError(w, r, "days must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	filter := bson.M{
+		"status":     scoredScans,
+		"runner":     bson.M{"$exists": true},
+		"created_at": bson.M{"$gte": time.Now().AddDate(0, 0, -v.Days)},
+	}
+	scans, err := FindAnalyticsScans(QueryReports, filter, options.Find())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	v.correlate(scans)
+	json.NewEncoder(w).Encode(&v)
+}
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	RunnerLighthouse  = "lighthouse"
+	RunnerPSI         = "psi"
+	RunnerWebPageTest = "webpagetest"
+)
+
+var (
+	psiEndpoint                 = "https://www.googleapis.com/pagespeedonline/v5/runPagespeed"
+	defaultWebPageTestURL       = "https://www.webpagetest.org"
+	webPageTestPollInterval     = 10 * time.Second
+	remoteRunnerClient          = &http.Client{}
+	errWebPageTestKeyMissing    = errors.New("runner_type webpagetest requires WEBPAGETEST_API_KEY to be set")
+	errRemoteRunnerNoLighthouse = errors.New("The response contains no Lighthouse result")
+)
+
+// ScanRunner audits the page of a scan. Run returns the Lighthouse JSON
+// result and, when the runner renders one, the HTML report. Validate
+// rejects the settings of scan the runner can't honour.
+type ScanRunner interface {
+	Validate(scan *Scan) error
+	Run(ctx context.Context, scan *Scan) (result []byte, html []byte, err error)
+}
+
+// scanRunners are the runners scans can pick with runner_type.
+var scanRunners = map[string]ScanRunner{
+	RunnerLighthouse:  lighthouseRunner{},
+	RunnerPSI:         psiRunner{},
+	RunnerWebPageTest: webPageTestRunner{},
+}
+
+// defaultRunnerType returns the runner of scans that don't pick one,
+// configured with SCAN_RUNNER.
+func defaultRunnerType() string {
+	if runner := os.Getenv("SCAN_RUNNER"); runner != "" {
+		return runner
+	}
+	return RunnerLighthouse
+}
+
+// runnerType returns the runner the scan runs with.
+func (scan *Scan) runnerType() string {
+	if scan.RunnerType != "" {
+		return scan.RunnerType
+	}
+	return defaultRunnerType()
+}
+
+// scanRunner returns the runner of scan. Besides requests, the runner type
+// can come from SCAN_RUNNER or from scans stored before a configuration
+// change, so it is checked again when the scan runs.
+func (scan *Scan) scanRunner() (ScanRunner, error) {
+	runner, ok := scanRunners[scan.runnerType()]
+	if !ok {
+		return nil, errors.New("Unknown runner_type " + scan.runnerType() + ", expected lighthouse, psi or webpagetest")
+	}
+	return runner, nil
+}
+
+// checkDefaultRunner exits when SCAN_RUNNER names an unknown runner.
+func checkDefaultRunner() {
+	if _, ok := scanRunners[defaultRunnerType()]; !ok {
+		log.Fatalf("Unknown SCAN_RUNNER %s, expected lighthouse, psi or webpagetest", defaultRunnerType())
+	}
+}
+
+func (scan *Scan) validateRunner() error {
+	runner, err := scan.scanRunner()
+	if err != nil {
+		return err
+	}
+	return runner.Validate(scan)
+}
+
+// localSetting returns the name of the first setting of scan that needs
+// the Chrome of the local Lighthouse CLI, or "" when there is none.
+func (scan *Scan) localSetting() string {
+	switch {
+	case scan.ChromeProfile != "":
+		return "chrome_profile"
+	case len(scan.ProtocolPresets) > 0:
+		return "protocol_presets"
+	case len(scan.HostOverrides) > 0:
+		return "host_overrides"
+	case scan.TargetAuth != nil:
+		return "target_auth"
+	case scan.Options != nil && len(scan.Options.ChromeFlags) > 0:
+		return "options.chrome_flags"
+	case scan.Options != nil && scan.Options.Throttling != "":
+		return "options.throttling"
+	}
+	return ""
+}
+
+// lighthouseRunner runs the Lighthouse CLI with a local Chrome.
+type lighthouseRunner struct{}
+
+func (lighthouseRunner) Validate(scan *Scan) error {
+	return nil
+}
+
+// Run runs Lighthouse for scan. Lighthouse and the Chrome it launched are
+// killed when ctx is done.
+func (lighthouseRunner) Run(ctx context.Context, scan *Scan) ([]byte, []byte, error) {
+	// Every scan writes into its own directory so concurrent scans can't
+	// overwrite each other's reports.
+	outputDir, err := ioutil.TempDir(reportsBaseDir(), scan.ID.Hex()+"-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(outputDir)
+	port := 0
+	if scan.usesChromePool() {
+		port = chromePool.Acquire()
+		defer chromePool.Release(port)
+	} else if scan.ChromeProfile == ChromeProfilePersistent {
+		// Chrome refuses to share a profile between two instances.
+		unlock := lockProfile(persistentProfileDir(scan.URL))
+		defer unlock()
+	}
+	args := lighthouseArgs(scan, port, outputDir)
+	cmd, err := scan.lighthouseCommand(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stdErr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(&stdErr, &progressWriter{scan: scan})
+	engineLog.Debugf("Running lighthouse %v", redactArgs(args))
+	if err = runProcessGroup(ctx, cmd); err != nil {
+		// Lighthouse logs the URL it audits, credentials included.
+		output := string(scan.scrubTargetAuth(stdErr.Bytes()))
+		engineLog.Errorf("Lighthouse failed: %v. Stderr: %s", err, output)
+		return nil, nil, fmt.Errorf("Lighthouse failed: %v: %s", err, tail(output, maxErrorOutput))
+	}
+	result, err := ioutil.ReadFile(filepath.Join(outputDir, "report.report.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	html, err := ioutil.ReadFile(filepath.Join(outputDir, "report.report.html"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return scan.scrubTargetAuth(result), scan.scrubTargetAuth(html), nil
+}
+
+// psiRunner runs Lighthouse through the Google PageSpeed Insights API, so
+// no local Chrome is needed. The page must be reachable from the internet.
+// PSI_API_KEY is sent along when set.
+type psiRunner struct{}
+
+func (psiRunner) Validate(scan *Scan) error {
+	if setting := scan.localSetting(); setting != "" {
+		return errors.New("runner_type psi doesn't support " + setting)
+	}
+	return nil
+}
+
+func (psiRunner) Run(ctx context.Context, scan *Scan) ([]byte, []byte, error) {
+	query := url.Values{"url": {scan.URL}, "strategy": {FormFactorMobile}}
+	if key := os.Getenv("PSI_API_KEY"); key != "" {
+		query.Set("key", key)
+	}
+	// Unlike Lighthouse, PageSpeed Insights only audits performance unless
+	// asked for more.
+	categories := compareCategories
+	if o := scan.Options; o != nil {
+		if len(o.Categories) > 0 {
+			categories = o.Categories
+		}
+		if o.FormFactor != "" {
+			query.Set("strategy", o.FormFactor)
+		}
+		if o.Locale != "" {
+			query.Set("locale", o.Locale)
+		}
+	}
+	for _, category := range categories {
+		query.Add("category", strings.ToUpper(strings.Replace(category, "-", "_", -1)))
+	}
+	var response struct {
+		LighthouseResult json.RawMessage `json:"lighthouseResult"`
+	}
+	if err := getRemoteJSON(ctx, "PageSpeed Insights", psiEndpoint+"?"+query.Encode(), &response); err != nil {
+		return nil, nil, err
+	}
+	if len(response.LighthouseResult) == 0 {
+		return nil, nil, errRemoteRunnerNoLighthouse
+	}
+	return response.LighthouseResult, nil, nil
+}
+
+// webPageTestRunner submits the scan as a WebPageTest test with its
+// Lighthouse run enabled and polls for the result. WEBPAGETEST_URL selects
+// a private instance instead of www.webpagetest.org.
+type webPageTestRunner struct{}
+
+func webPageTestURL() string {
+	if u := os.Getenv("WEBPAGETEST_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return defaultWebPageTestURL
+}
+
+func (webPageTestRunner) Validate(scan *Scan) error {
+	if os.Getenv("WEBPAGETEST_API_KEY") == "" {
+		return errWebPageTestKeyMissing
+	}
+	if setting := scan.localSetting(); setting != "" {
+		return errors.New("runner_type webpagetest doesn't support " + setting)
+	}
+	if o := scan.Options; o != nil && (len(o.Categories) > 0 || o.Locale != "") {
+		return errors.New("runner_type webpagetest doesn't support options.categories and options.locale")
+	}
+	return nil
+}
+
+func (webPageTestRunner) Run(ctx context.Context, scan *Scan) ([]byte, []byte, error) {
+	query := url.Values{"url": {scan.URL}, "k": {os.Getenv("WEBPAGETEST_API_KEY")}, "f": {"json"},
+		"lighthouse": {"1"}, "runs": {"1"}, "mobile": {"1"}}
+	if scan.Options != nil && scan.Options.FormFactor == FormFactorDesktop {
+		query.Del("mobile")
+	}
+	var test struct {
+		StatusCode int    `json:"statusCode"`
+		StatusText string `json:"statusText"`
+		Data       struct {
+			TestID string `json:"testId"`
+		} `json:"data"`
+	}
+	if err := getRemoteJSON(ctx, "WebPageTest", webPageTestURL()+"/runtest.php?"+query.Encode(), &test); err != nil {
+		return nil, nil, err
+	}
+	if test.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("WebPageTest refused the test: %s", test.StatusText)
+	}
+	engineLog.Debugf("Started WebPageTest test %s for scan %s", test.Data.TestID, scan.ID.Hex())
+	resultURL := webPageTestURL() + "/jsonResult.php?test=" + url.QueryEscape(test.Data.TestID)
+	for {
+		// Status codes 1xx mean the test is still queued or running.
+		var result struct {
+			StatusCode int    `json:"statusCode"`
+			StatusText string `json:"statusText"`
+			Data       struct {
+				Lighthouse json.RawMessage `json:"lighthouse"`
+			} `json:"data"`
+		}
+		if err := getRemoteJSON(ctx, "WebPageTest", resultURL, &result); err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case result.StatusCode == http.StatusOK:
+			if len(result.Data.Lighthouse) == 0 {
+				return nil, nil, errRemoteRunnerNoLighthouse
+			}
+			return result.Data.Lighthouse, nil, nil
+		case result.StatusCode >= 400:
+			return nil, nil, fmt.Errorf("WebPageTest test %s failed: %s", test.Data.TestID, result.StatusText)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(webPageTestPollInterval):
+		}
+	}
+}
+
+// getRemoteJSON decodes the JSON response to a GET of rawURL into v. The
+// error names service, the remote runner rawURL belongs to, but not rawURL
+// itself: its query carries the API key of the runner.
+func getRemoteJSON(ctx context.Context, service string, rawURL string, v interface{}) error {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := remoteRunnerClient.Do(req.WithContext(ctx))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s request failed: %v", service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorOutput))
+		return fmt.Errorf("%s responded with %s: %s", service, resp.Status, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Decoding %s response failed: %v", service, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunScanWithUnknownRunner(t *testing.T) {
+	scan := &Scan{URL: "https://reviewor.org", RunnerType: "sitespeed"}
+	err := runScan(context.Background(), scan)
+	if err == nil || !strings.Contains(err.Error(), "Unknown runner_type sitespeed") {
+		t.Errorf("Expected the scan to fail with an unknown runner. Got %v", err)
+	}
+}
+
+func TestRemoteRunnerErrorHidesAPIKey(t *testing.T) {
+	defer func(endpoint string) { psiEndpoint = endpoint }(psiEndpoint)
+	// Nothing listens on port 1, so the request fails in the transport.
+	psiEndpoint = "http://127.0.0.1:1/runPagespeed"
+	os.Setenv("PSI_API_KEY", "psi-secret-key")
+	defer os.Unsetenv("PSI_API_KEY")
+	_, _, err := psiRunner{}.Run(context.Background(), &Scan{URL: "https://reviewor.org"})
+	if err == nil {
+		t.Fatal("Expected the PageSpeed Insights request to fail")
+	}
+	if strings.Contains(err.Error(), "psi-secret-key") {
+		t.Errorf("Expected the error to hide the API key. Got %v", err)
+	}
+}
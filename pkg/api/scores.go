@@ -0,0 +1,106 @@
+package api
+
+import "strings"
+
+// Scores holds the Lighthouse category scores of a scan, between 0 and 1.
+// A score is nil when the category was not audited or could not be scored.
+type Scores struct {
+	Performance   *float64 `json:"performance" bson:"performance"`
+	Accessibility *float64 `json:"accessibility" bson:"accessibility"`
+	BestPractices *float64 `json:"best_practices" bson:"best_practices"`
+	SEO           *float64 `json:"seo" bson:"seo"`
+	PWA           *float64 `json:"pwa" bson:"pwa"`
+}
+
+// Metrics holds the key lab metrics of a scan. Timings are in
+// milliseconds, CLS is unitless.
+type Metrics struct {
+	FirstContentfulPaint   *float64 `json:"fcp" bson:"fcp"`
+	LargestContentfulPaint *float64 `json:"lcp" bson:"lcp"`
+	TotalBlockingTime      *float64 `json:"tbt" bson:"tbt"`
+	CumulativeLayoutShift  *float64 `json:"cls" bson:"cls"`
+	SpeedIndex             *float64 `json:"si" bson:"si"`
+	Interactive            *float64 `json:"tti" bson:"tti"`
+}
+
+// categoryNames and metricNames are the names accepted by Scan.Value.
+var (
+	categoryNames = map[string]bool{"performance": true, "accessibility": true,
+		"best-practices": true, "seo": true, "pwa": true}
+	metricNames = map[string]bool{"fcp": true, "lcp": true, "tbt": true, "cls": true,
+		"si": true, "tti": true}
+)
+
+// higherIsBetter reports whether a higher value of the named score or
+// metric is an improvement. That holds for category scores, while lower
+// metrics are better.
+func higherIsBetter(name string) bool {
+	return categoryNames[name]
+}
+
+func scoresFromReport(report *LighthouseReport) *Scores {
+	return &Scores{
+		Performance:   report.CategoryScore("performance"),
+		Accessibility: report.CategoryScore("accessibility"),
+		BestPractices: report.CategoryScore("best-practices"),
+		SEO:           report.CategoryScore("seo"),
+		PWA:           report.CategoryScore("pwa"),
+	}
+}
+
+func metricsFromReport(report *LighthouseReport) *Metrics {
+	return &Metrics{
+		FirstContentfulPaint:   report.AuditValue("first-contentful-paint"),
+		LargestContentfulPaint: report.AuditValue("largest-contentful-paint"),
+		TotalBlockingTime:      report.AuditValue("total-blocking-time"),
+		CumulativeLayoutShift:  report.AuditValue("cumulative-layout-shift"),
+		SpeedIndex:             report.AuditValue("speed-index"),
+		Interactive:            report.AuditValue("interactive"),
+	}
+}
+
+// Value returns the category score or metric of the scan with the given
+// name, a Lighthouse category ID, metric abbreviation such as lcp, resource
+// size such as script-bytes or custom metric name, or nil when it is
+// unknown or not recorded.
+func (scan *Scan) Value(name string) *float64 {
+	if s := scan.Scores; s != nil {
+		switch name {
+		case "performance":
+			return s.Performance
+		case "accessibility":
+			return s.Accessibility
+		case "best-practices":
+			return s.BestPractices
+		case "seo":
+			return s.SEO
+		case "pwa":
+			return s.PWA
+		}
+	}
+	if m := scan.Metrics; m != nil {
+		switch name {
+		case "fcp":
+			return m.FirstContentfulPaint
+		case "lcp":
+			return m.LargestContentfulPaint
+		case "tbt":
+			return m.TotalBlockingTime
+		case "cls":
+			return m.CumulativeLayoutShift
+		case "si":
+			return m.SpeedIndex
+		case "tti":
+			return m.Interactive
+		}
+	}
+	if strings.HasSuffix(name, "-bytes") {
+		if v, ok := scan.ResourceSizes[strings.TrimSuffix(name, "-bytes")]; ok {
+			return &v
+		}
+	}
+	if v, ok := scan.CustomMetrics[name]; ok {
+		return &v
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"math/bits"
+	"net/http"
+	"strings"
+
+	"github.com/rs/xid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// visualDiffThreshold is how much the luma of a pixel, between 0 and 255,
+// has to change for the pixel to count as changed. Smaller changes are
+// mostly JPEG artifacts.
+const visualDiffThreshold = 32
+
+// FinalScreenshot returns the image Lighthouse captured of the page at the
+// end of the run, or nil when the report has none.
+func (r *LighthouseReport) FinalScreenshot() ([]byte, error) {
+	a, ok := r.Audits["final-screenshot"]
+	if !ok || len(a.Details) == 0 {
+		return nil, nil
+	}
+	var details struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(a.Details, &details); err != nil {
+		return nil, err
+	}
+	// The screenshot is a data URL, e.g. data:image/jpeg;base64,...
+	i := strings.Index(details.Data, ";base64,")
+	if i < 0 {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(details.Data[i+len(";base64,"):])
+}
+
+// perceptualHash returns the difference hash of img. Each of the 64 bits
+// tells whether a cell of a 9x8 grayscale thumbnail of img is brighter
+// than its right neighbour, so look-alike images have hashes that differ
+// in few bits.
+func perceptualHash(img image.Image) uint64 {
+	cells := thumbnail(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// thumbnail scales img down to width x height cells holding the mean luma
+// of the pixels they cover.
+func thumbnail(img image.Image, width, height int) [][]float64 {
+	b := img.Bounds()
+	sums := make([][]float64, height)
+	counts := make([][]float64, height)
+	for y := range sums {
+		sums[y] = make([]float64, width)
+		counts[y] = make([]float64, width)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * height / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * width / b.Dx()
+			sums[cy][cx] += float64(luma(img.At(x, y)))
+			counts[cy][cx]++
+		}
+	}
+	for y := range sums {
+		for x := range sums[y] {
+			if counts[y][x] > 0 {
+				sums[y][x] /= counts[y][x]
+			}
+		}
+	}
+	return sums
+}
+
+func luma(c color.Color) uint8 {
+	return color.GrayModel.Convert(c).(color.Gray).Y
+}
+
+// storeScreenshot stores the final screenshot of report in Reports along
+// with its perceptual hash. Scans whose report has no screenshot, e.g.
+// because the page failed to render, are left without one.
+func (scan *Scan) storeScreenshot(report *LighthouseReport) error {
+	scan.ScreenshotLocation, scan.ScreenshotHash = "", ""
+	data, err := report.FinalScreenshot()
+	if err != nil || data == nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Decoding screenshot failed: %v", err)
+	}
+	if scan.ScreenshotLocation, err = writeReport(xid.New().String()+".jpg", data); err != nil {
+		return err
+	}
+	scan.ScreenshotHash = fmt.Sprintf("%016x", perceptualHash(img))
+	return nil
+}
+
+func readScreenshot(scan *Scan) (image.Image, error) {
+	data, err := readReport(scan.ScreenshotLocation)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// VisualDiff is the difference between the screenshots of two scans of the
+// same URL, along with the difference of their scores and metrics.
+type VisualDiff struct {
+	Base primitive.ObjectID `json:"base"`
+	Head primitive.ObjectID `json:"head"`
+	// HashDistance is the number of bits the perceptual hashes of the
+	// screenshots differ in, from 0 for look-alikes to 64.
+	HashDistance int `json:"hash_distance"`
+	// ChangedShare is the share of pixels that changed, counting the pixels
+	// only one of the screenshots has as changed.
+	ChangedShare float64 `json:"changed_share"`
+	// ChangedArea bounds the changed pixels. It is null when no pixel
+	// changed.
+	ChangedArea *Area           `json:"changed_area"`
+	Comparison  *ScanComparison `json:"comparison"`
+}
+
+type Area struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// diffImages compares base and head pixel by pixel. It returns a dimmed
+// copy of head with the changed pixels highlighted in red, the number of
+// changed pixels and the rectangle bounding them.
+func diffImages(base image.Image, head image.Image) (*image.RGBA, int, image.Rectangle) {
+	bs, hs := base.Bounds().Size(), head.Bounds().Size()
+	size := image.Pt(bs.X, bs.Y)
+	if hs.X > size.X {
+		size.X = hs.X
+	}
+	if hs.Y > size.Y {
+		size.Y = hs.Y
+	}
+	highlight := image.NewRGBA(image.Rectangle{Max: size})
+	draw.Draw(highlight, highlight.Bounds(), image.White, image.ZP, draw.Src)
+	changed := 0
+	area := image.Rectangle{}
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			p := image.Pt(x, y)
+			inBase, inHead := p.In(image.Rectangle{Max: bs}), p.In(image.Rectangle{Max: hs})
+			var headLuma uint8
+			if inHead {
+				headLuma = luma(head.At(head.Bounds().Min.X+x, head.Bounds().Min.Y+y))
+				highlight.Set(x, y, color.Gray{Y: 128 + headLuma/2})
+			}
+			if inBase && inHead {
+				d := int(luma(base.At(base.Bounds().Min.X+x, base.Bounds().Min.Y+y))) - int(headLuma)
+				if d < visualDiffThreshold && d > -visualDiffThreshold {
+					continue
+				}
+			}
+			highlight.Set(x, y, color.RGBA{R: 255, A: 255})
+			changed++
+			area = area.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	return highlight, changed, area
+}
+
+// getVisualDiff diffs the screenshots of the scans ?base and ?head, which
+// must be of the same URL. With ?format=png it returns the screenshot of
+// head with the changed pixels highlighted instead.
+func (a *App) getVisualDiff(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+	if query.Get("base") == "" || query.Get("head") == "" {
+		writeError(w, r, "Query parameters base and head are required", http.StatusBadRequest)
+		return
+	}
+	if format := query.Get("format"); format != "" && format != "json" && format != "png" {
+		writeError(w, r, "Unknown format "+format+", expected json or png", http.StatusBadRequest)
+		return
+	}
+	base, err := GetScanByObjectIDHex(query.Get("base"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	head, err := GetScanByObjectIDHex(query.Get("head"))
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if base.URL != head.URL {
+		writeError(w, r, "Only scans of the same URL can be diffed", http.StatusBadRequest)
+		return
+	}
+	images := make([]image.Image, 2)
+	for i, scan := range []*Scan{&base, &head} {
+		if scan.ScreenshotLocation == "" {
+			writeError(w, r, "The screenshot of scan "+scan.ID.Hex()+" is not available", http.StatusNotFound)
+			return
+		}
+		if images[i], err = readScreenshot(scan); err != nil {
+			writeReportError(w, r, scan, err)
+			return
+		}
+	}
+	highlight, changed, area := diffImages(images[0], images[1])
+	if query.Get("format") == "png" {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, highlight)
+		return
+	}
+	annotated, err := annotatedAudits(head.URL)
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	size := highlight.Bounds().Size()
+	diff := VisualDiff{
+		Base:         base.ID,
+		Head:         head.ID,
+		HashDistance: bits.OnesCount64(perceptualHash(images[0]) ^ perceptualHash(images[1])),
+		Comparison:   compareScans(&base, &head, annotated),
+	}
+	if size.X*size.Y > 0 {
+		diff.ChangedShare = float64(changed) / float64(size.X*size.Y)
+	}
+	if changed > 0 {
+		diff.ChangedArea = &Area{X: area.Min.X, Y: area.Min.Y, Width: area.Dx(), Height: area.Dy()}
+	}
+	json.NewEncoder(w).Encode(&diff)
+}
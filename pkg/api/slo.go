@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultSLOWindowHours      = 24
+	defaultSLOSuccessRate      = 0.99
+	defaultSLOQueueWaitSeconds = 300
+)
+
+// siteErrorCodes are the Lighthouse runtime errors caused by the scanned
+// page rather than by the service, e.g. a site that is down. Scans failing
+// with them don't count against the success rate SLO.
+var siteErrorCodes = []string{"DNS_FAILURE", "FAILED_DOCUMENT_REQUEST", "ERRORED_DOCUMENT_REQUEST",
+	"INSECURE_DOCUMENT_REQUEST", "CHROME_INTERSTITIAL_ERROR", "PAGE_HUNG", "NO_FCP", "NOT_HTML"}
+
+// SLO holds the objectives of the service itself: the share of scans that
+// don't fail because of the service and the 95th percentile of the time
+// scans wait for a worker, over the last WindowHours hours.
+type SLO struct {
+	WindowHours      int
+	SuccessRate      float64
+	QueueWaitSeconds float64
+}
+
+// CreateSLO reads the objectives from SLO_WINDOW_HOURS, SLO_SUCCESS_RATE
+// and SLO_QUEUE_WAIT_SECONDS.
+func CreateSLO() SLO {
+	slo := SLO{WindowHours: defaultSLOWindowHours, SuccessRate: defaultSLOSuccessRate,
+		QueueWaitSeconds: defaultSLOQueueWaitSeconds}
+	if hours, err := strconv.Atoi(os.Getenv("SLO_WINDOW_HOURS")); err == nil && hours > 0 {
+		slo.WindowHours = hours
+	}
+	if rate, err := strconv.ParseFloat(os.Getenv("SLO_SUCCESS_RATE"), 64); err == nil && rate > 0 && rate < 1 {
+		slo.SuccessRate = rate
+	}
+	if wait, err := strconv.ParseFloat(os.Getenv("SLO_QUEUE_WAIT_SECONDS"), 64); err == nil && wait > 0 {
+		slo.QueueWaitSeconds = wait
+	}
+	return slo
+}
+
+// Stats is how the service fares against its SLO, as returned by
+// GET /stats. Jobs that were cancelled are left out.
+type Stats struct {
+	WindowHours int `json:"window_hours"`
+	// Jobs counts the finished jobs of the window, by whether they
+	// succeeded, failed because of the page or failed because of the
+	// service.
+	Jobs             int `json:"jobs"`
+	Succeeded        int `json:"succeeded"`
+	SiteFailures     int `json:"site_failures"`
+	PlatformFailures int `json:"platform_failures"`
+	// SuccessRate is the share of jobs that didn't fail because of the
+	// service. ErrorBudgetRemaining is the share of the failures the
+	// target allows that are left, negative once the budget is exceeded.
+	// Both are null without jobs.
+	SuccessRate          *float64 `json:"success_rate"`
+	SuccessRateTarget    float64  `json:"success_rate_target"`
+	ErrorBudgetRemaining *float64 `json:"error_budget_remaining"`
+	// QueueWaitP95Seconds is null when no job of the window started.
+	QueueWaitP95Seconds    *float64 `json:"queue_wait_p95_seconds"`
+	QueueWaitTargetSeconds float64  `json:"queue_wait_target_seconds"`
+	Queued                 int      `json:"queued"`
+	Running                int      `json:"running"`
+	// Breached lists the objectives that are missed: success_rate and
+	// queue_wait.
+	Breached []string `json:"breached"`
+}
+
+// isSiteFailure reports whether a job failed because of the page it
+// scanned.
+func isSiteFailure(job *Job) bool {
+	for _, code := range siteErrorCodes {
+		if strings.Contains(job.Error, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// percentile returns the p-th percentile of values using the nearest rank,
+// or nil without values.
+func percentile(values []float64, p float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return &sorted[rank]
+}
+
+// Stats measures the service against the SLO from the jobs created within
+// the window before now.
+func (slo SLO) Stats(now time.Time) (*Stats, error) {
+	s := &Stats{WindowHours: slo.WindowHours, SuccessRateTarget: slo.SuccessRate,
+		QueueWaitTargetSeconds: slo.QueueWaitSeconds, Breached: []string{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("jobs")
+	filter := bson.M{"created_at": bson.M{"$gte": now.Add(-time.Duration(slo.WindowHours) * time.Hour)}}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []Job{}
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	waits := []float64{}
+	for i := range jobs {
+		job := &jobs[i]
+		if job.StartedAt != nil {
+			waits = append(waits, job.StartedAt.Sub(job.CreatedAt).Seconds())
+		}
+		switch job.Status {
+		case JobQueued:
+			s.Queued++
+			// Jobs still waiting count with the time they waited so far.
+			waits = append(waits, now.Sub(job.CreatedAt).Seconds())
+		case JobRunning:
+			s.Running++
+		case JobDone:
+			s.Jobs++
+			s.Succeeded++
+		case JobFailed, JobInterrupted:
+			s.Jobs++
+			if isSiteFailure(job) {
+				s.SiteFailures++
+			} else {
+				s.PlatformFailures++
+			}
+		}
+	}
+	if s.Jobs > 0 {
+		rate := 1 - float64(s.PlatformFailures)/float64(s.Jobs)
+		s.SuccessRate = &rate
+		budget := 1 - float64(s.PlatformFailures)/((1-slo.SuccessRate)*float64(s.Jobs))
+		s.ErrorBudgetRemaining = &budget
+		if rate < slo.SuccessRate {
+			s.Breached = append(s.Breached, "success_rate")
+		}
+	}
+	if s.QueueWaitP95Seconds = percentile(waits, 95); s.QueueWaitP95Seconds != nil &&
+		*s.QueueWaitP95Seconds > slo.QueueWaitSeconds {
+		s.Breached = append(s.Breached, "queue_wait")
+	}
+	return s, nil
+}
+
+// Monitor notifies the webhooks subscribed to slo.breached whenever an
+// objective starts being missed, checking every interval until the
+// process exits.
+func (slo SLO) Monitor(interval time.Duration) {
+	breached := make(map[string]bool)
+	for {
+		time.Sleep(interval)
+		stats, err := slo.Stats(time.Now())
+		if err != nil {
+			storeLog.Errorf("Measuring the SLO failed: %v", err)
+			continue
+		}
+		now := make(map[string]bool)
+		started := false
+		for _, objective := range stats.Breached {
+			now[objective] = true
+			started = started || !breached[objective]
+		}
+		breached = now
+		if !started {
+			continue
+		}
+		engineLog.Warnf("SLO breached: %v", stats.Breached)
+		hooks, err := GetAllWebhooks()
+		if err != nil {
+			storeLog.Errorf("Loading webhooks failed: %v", err)
+			continue
+		}
+		sendWebhookEvent(hooks, WebhookEvent{Event: EventSLOBreached, Stats: stats})
+	}
+}
+
+func (a *App) getStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	stats, err := CreateSLO().Stats(time.Now())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(stats)
+}
+
+// getMetrics exposes the stats in the Prometheus text format.
+func (a *App) getMetrics(w http.ResponseWriter, r *http.Request) {
+	stats, err := CreateSLO().Stats(time.Now())
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	gauge := func(name string, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+	}
+	fmt.Fprintf(w, "# HELP websu_slo_jobs Finished jobs of the SLO window by outcome.\n# TYPE websu_slo_jobs gauge\n")
+	fmt.Fprintf(w, "websu_slo_jobs{outcome=\"succeeded\"} %d\n", stats.Succeeded)
+	fmt.Fprintf(w, "websu_slo_jobs{outcome=\"site_failure\"} %d\n", stats.SiteFailures)
+	fmt.Fprintf(w, "websu_slo_jobs{outcome=\"platform_failure\"} %d\n", stats.PlatformFailures)
+	if stats.SuccessRate != nil {
+		gauge("websu_slo_success_rate", "Share of jobs that did not fail because of the service.", *stats.SuccessRate)
+		gauge("websu_slo_error_budget_remaining", "Share of the error budget left.", *stats.ErrorBudgetRemaining)
+	}
+	gauge("websu_slo_success_rate_target", "Success rate objective.", stats.SuccessRateTarget)
+	if stats.QueueWaitP95Seconds != nil {
+		gauge("websu_slo_queue_wait_p95_seconds", "95th percentile of the time jobs waited for a worker.",
+			*stats.QueueWaitP95Seconds)
+	}
+	gauge("websu_slo_queue_wait_target_seconds", "Queue wait objective.", stats.QueueWaitTargetSeconds)
+	gauge("websu_jobs_queued", "Jobs waiting for a worker.", float64(stats.Queued))
+	gauge("websu_jobs_running", "Jobs running.", float64(stats.Running))
+}
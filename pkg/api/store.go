@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Scans stores the scans. It is Mongo unless SCAN_STORE selects another
+// backend, see CreateScanStore.
+var Scans ScanStore = mongoScanStore{}
+
+// ErrScansNotInMongo is returned by the queries that read the Mongo scans
+// collection directly, i.e. the reporting endpoints and /admin/query, when
+// the scans are stored elsewhere.
+var ErrScansNotInMongo = errors.New("This endpoint is only available when scans are stored in Mongo")
+
+// scansInMongo reports whether Scans is the Mongo store.
+func scansInMongo() bool {
+	_, ok := Scans.(mongoScanStore)
+	return ok
+}
+
+// ScanStore persists scans. Get and Latest return mongo.ErrNoDocuments
+// when no scan matches, whatever the backend.
+//
+// Only the scan lifecycle and the scan endpoints go through the store.
+// Reporting endpoints like forecasts or trends query Mongo directly.
+type ScanStore interface {
+	Create(scan *Scan) error
+	Get(id primitive.ObjectID) (Scan, error)
+	Update(scan *Scan) error
+	SetStatus(id primitive.ObjectID, status string, errMsg string) error
+	Delete(id primitive.ObjectID) error
+	List(q ScanQuery) ([]Scan, error)
+	Count(q ScanQuery) (int64, error)
+	// Latest returns the newest scan of url that has results.
+	Latest(url string) (Scan, error)
+}
+
+// ScanQuery selects scans. Zero fields don't filter.
+type ScanQuery struct {
+	URL string
+	// URLPrefix matches scans whose URL starts with it.
+	URLPrefix     string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Status        string
+	Project       primitive.ObjectID
+	// Tag matches scans that have it among their tags.
+	Tag string
+	// MinPerformance and MaxPerformance bound the performance score,
+	// leaving out scans that have none.
+	MinPerformance *float64
+	MaxPerformance *float64
+	// HasReport only matches scans whose report is still stored.
+	HasReport bool
+	// Sort is a list of fields and 1 or -1 as returned by parseSort.
+	Sort  bson.D
+	Skip  int64
+	Limit int64
+	// Projection limits the fields loaded from stores that support it.
+	Projection bson.M
+}
+
+// CreateScanStore sets up the store selected with SCAN_STORE: mongo, the
+// default, or postgres, which connects to POSTGRES_URI.
+func CreateScanStore() {
+	switch backend := os.Getenv("SCAN_STORE"); backend {
+	case "", "mongo":
+		Scans = mongoScanStore{}
+	case "postgres":
+		store, err := NewPostgresScanStore(os.Getenv("POSTGRES_URI"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		Scans = store
+	default:
+		log.Fatalf("Unknown SCAN_STORE %s, expected mongo or postgres", backend)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoScanStore keeps scans in the scans collection of DB.
+type mongoScanStore struct{}
+
+func (mongoScanStore) collection() *mongo.Collection {
+	return DB.Database("websu").Collection("scans")
+}
+
+func (s mongoScanStore) Create(scan *Scan) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := s.collection().InsertOne(ctx, scan)
+	return err
+}
+
+func (s mongoScanStore) Get(id primitive.ObjectID) (Scan, error) {
+	var scan Scan
+	err := s.collection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&scan)
+	return scan, err
+}
+
+func (s mongoScanStore) Update(scan *Scan) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := s.collection().ReplaceOne(ctx, bson.M{"_id": scan.ID}, scan)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound("Scan", scan.ID)
+	}
+	return nil
+}
+
+func (s mongoScanStore) SetStatus(id primitive.ObjectID, status string, errMsg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	set := bson.M{"status": status}
+	if errMsg != "" {
+		set["error"] = errMsg
+	}
+	_, err := s.collection().UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+func (s mongoScanStore) Delete(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := s.collection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound("Scan", id)
+	}
+	return nil
+}
+
+func (mongoScanStore) filter(q ScanQuery) bson.M {
+	filter := bson.M{}
+	if q.URL != "" {
+		filter["url"] = q.URL
+	} else if q.URLPrefix != "" {
+		// An anchored regular expression without options can use the
+		// url index.
+		filter["url"] = bson.M{"$regex": "^" + regexp.QuoteMeta(q.URLPrefix)}
+	}
+	createdAt := bson.M{}
+	if !q.CreatedAfter.IsZero() {
+		createdAt["$gte"] = q.CreatedAfter
+	}
+	if !q.CreatedBefore.IsZero() {
+		createdAt["$lt"] = q.CreatedBefore
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+	if q.Status != "" {
+		filter["status"] = q.Status
+	}
+	if !q.Project.IsZero() {
+		filter["project"] = q.Project
+	}
+	if q.Tag != "" {
+		filter["tags"] = q.Tag
+	}
+	performance := bson.M{}
+	if q.MinPerformance != nil {
+		performance["$gte"] = *q.MinPerformance
+	}
+	if q.MaxPerformance != nil {
+		performance["$lte"] = *q.MaxPerformance
+	}
+	if len(performance) > 0 {
+		filter["scores.performance"] = performance
+	}
+	if q.HasReport {
+		filter["jsonLocation"] = bson.M{"$exists": true, "$ne": ""}
+	}
+	return filter
+}
+
+func (s mongoScanStore) List(q ScanQuery) ([]Scan, error) {
+	opts := options.Find()
+	if q.Sort != nil {
+		opts.SetSort(q.Sort)
+	}
+	if q.Skip > 0 {
+		opts.SetSkip(q.Skip)
+	}
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit)
+	}
+	if q.Projection != nil {
+		opts.SetProjection(q.Projection)
+	}
+	return findScans(s.collection(), s.filter(q), opts)
+}
+
+func (s mongoScanStore) Count(q ScanQuery) (int64, error) {
+	return s.collection().CountDocuments(context.TODO(), s.filter(q))
+}
+
+func (s mongoScanStore) Latest(url string) (Scan, error) {
+	var scan Scan
+	opts := options.FindOne().SetSort(bson.M{"created_at": -1})
+	filter := bson.M{"url": url, "status": scoredScans}
+	err := s.collection().FindOne(context.Background(), filter, opts).Decode(&scan)
+	return scan, err
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// postgresSortColumns maps the sortable scan fields to their columns.
+var postgresSortColumns = map[string]string{"created_at": "created_at", "url": "url"}
+
+// PostgresScanStore keeps scans in the scans table of a Postgres database,
+// for small deployments. The fields that are filtered on have their own
+// columns, the whole scan is stored as BSON in doc so it round-trips like
+// it does with Mongo.
+type PostgresScanStore struct {
+	db *sql.DB
+}
+
+func NewPostgresScanStore(uri string) (*PostgresScanStore, error) {
+	if uri == "" {
+		return nil, errors.New("SCAN_STORE postgres requires POSTGRES_URI to be configured")
+	}
+	db, err := sql.Open("postgres", uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS scans (
+			id TEXT PRIMARY KEY,
+			url TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT '',
+			has_report BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMPTZ NOT NULL,
+			doc BYTEA NOT NULL
+		);
+		ALTER TABLE scans ADD COLUMN IF NOT EXISTS performance DOUBLE PRECISION;
+		ALTER TABLE scans ADD COLUMN IF NOT EXISTS project TEXT;
+		ALTER TABLE scans ADD COLUMN IF NOT EXISTS tags TEXT[];
+		CREATE INDEX IF NOT EXISTS scans_status ON scans (status);
+		CREATE INDEX IF NOT EXISTS scans_url_created_at ON scans (url, created_at);
+		CREATE INDEX IF NOT EXISTS scans_created_at ON scans (created_at);`)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresScanStore{db: db}, nil
+}
+
+func (s *PostgresScanStore) Create(scan *Scan) error {
+	doc, err := bson.Marshal(scan)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO scans (id, url, status, has_report, created_at, performance, project, tags, doc)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		scan.ID.Hex(), scan.URL, scan.Status, scan.JsonLocation != "", scan.CreatedAt, scan.performance(),
+		scan.project(), pq.Array(scan.Tags), doc)
+	return err
+}
+
+func decodeScan(row interface{ Scan(...interface{}) error }) (Scan, error) {
+	var scan Scan
+	var doc []byte
+	if err := row.Scan(&doc); err == sql.ErrNoRows {
+		return scan, mongo.ErrNoDocuments
+	} else if err != nil {
+		return scan, err
+	}
+	err := bson.Unmarshal(doc, &scan)
+	return scan, err
+}
+
+func (s *PostgresScanStore) Get(id primitive.ObjectID) (Scan, error) {
+	return decodeScan(s.db.QueryRow(`SELECT doc FROM scans WHERE id = $1`, id.Hex()))
+}
+
+func (s *PostgresScanStore) Update(scan *Scan) error {
+	doc, err := bson.Marshal(scan)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE scans SET url = $2, status = $3, has_report = $4, performance = $5, project = $6, tags = $7,
+		doc = $8 WHERE id = $1`,
+		scan.ID.Hex(), scan.URL, scan.Status, scan.JsonLocation != "", scan.performance(),
+		scan.project(), pq.Array(scan.Tags), doc)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound("Scan", scan.ID)
+	}
+	return nil
+}
+
+// SetStatus updates the stored scan in a transaction, as the status is
+// part of the encoded document.
+func (s *PostgresScanStore) SetStatus(id primitive.ObjectID, status string, errMsg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	scan, err := decodeScan(tx.QueryRowContext(ctx, `SELECT doc FROM scans WHERE id = $1 FOR UPDATE`, id.Hex()))
+	if err == mongo.ErrNoDocuments {
+		// Like with Mongo, setting the status of a missing scan is a no-op.
+		return nil
+	} else if err != nil {
+		return err
+	}
+	scan.Status = status
+	if errMsg != "" {
+		scan.Error = errMsg
+	}
+	doc, err := bson.Marshal(&scan)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE scans SET status = $2, doc = $3 WHERE id = $1`,
+		id.Hex(), status, doc); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresScanStore) Delete(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := s.db.ExecContext(ctx, `DELETE FROM scans WHERE id = $1`, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotFound("Scan", id)
+	}
+	return nil
+}
+
+// likePrefix escapes the wildcards of a LIKE pattern.
+var likePrefix = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// performance returns the performance score of the scan, which has its own
+// column to filter on.
+func (scan *Scan) performance() *float64 {
+	if scan.Scores == nil {
+		return nil
+	}
+	return scan.Scores.Performance
+}
+
+// project returns the project ID of the scan for the project column.
+func (scan *Scan) project() *string {
+	if scan.Project == nil {
+		return nil
+	}
+	hex := scan.Project.Hex()
+	return &hex
+}
+
+// where returns the WHERE clause selecting the scans of q and its
+// arguments.
+func (s *PostgresScanStore) where(q ScanQuery) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if q.URL != "" {
+		add("url = $%d", q.URL)
+	} else if q.URLPrefix != "" {
+		add("url LIKE $%d", likePrefix.Replace(q.URLPrefix)+"%")
+	}
+	if !q.CreatedAfter.IsZero() {
+		add("created_at >= $%d", q.CreatedAfter)
+	}
+	if !q.CreatedBefore.IsZero() {
+		add("created_at < $%d", q.CreatedBefore)
+	}
+	if q.Status != "" {
+		add("status = $%d", q.Status)
+	}
+	if !q.Project.IsZero() {
+		add("project = $%d", q.Project.Hex())
+	}
+	if q.Tag != "" {
+		add("$%d = ANY(tags)", q.Tag)
+	}
+	if q.MinPerformance != nil {
+		add("performance >= $%d", *q.MinPerformance)
+	}
+	if q.MaxPerformance != nil {
+		add("performance <= $%d", *q.MaxPerformance)
+	}
+	if q.HasReport {
+		conditions = append(conditions, "has_report")
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *PostgresScanStore) List(q ScanQuery) ([]Scan, error) {
+	where, args := s.where(q)
+	query := `SELECT doc FROM scans` + where
+	if len(q.Sort) > 0 {
+		order := []string{}
+		for _, e := range q.Sort {
+			column, ok := postgresSortColumns[e.Key]
+			if !ok {
+				return nil, fmt.Errorf("Sorting by %q is not supported", e.Key)
+			}
+			if e.Value == -1 {
+				column += " DESC"
+			}
+			order = append(order, column)
+		}
+		query += " ORDER BY " + strings.Join(order, ", ")
+	}
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	if q.Skip > 0 {
+		args = append(args, q.Skip)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scans := []Scan{}
+	for rows.Next() {
+		scan, err := decodeScan(rows)
+		if err != nil {
+			return nil, err
+		}
+		scans = append(scans, scan)
+	}
+	return scans, rows.Err()
+}
+
+func (s *PostgresScanStore) Count(q ScanQuery) (int64, error) {
+	where, args := s.where(q)
+	var count int64
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM scans`+where, args...).Scan(&count)
+	return count, err
+}
+
+func (s *PostgresScanStore) Latest(url string) (Scan, error) {
+	return decodeScan(s.db.QueryRow(`SELECT doc FROM scans WHERE url = $1 AND status NOT IN ($2, $3, $4, $5, $6)
+		ORDER BY created_at DESC LIMIT 1`, url, ScanPending, ScanRunning, ScanFailed, ScanCancelled, ScanTimedOut))
+}
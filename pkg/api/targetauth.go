@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// TargetAuth holds basic auth credentials for the scanned site. They are
+// stored encrypted with TARGET_AUTH_KEY and the password is never returned
+// by the API.
+type TargetAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+}
+
+// targetAuthKey returns the AES key configured in TARGET_AUTH_KEY as
+// base64 of 16, 24 or 32 bytes.
+func targetAuthKey() ([]byte, error) {
+	encoded := os.Getenv("TARGET_AUTH_KEY")
+	if encoded == "" {
+		return nil, errors.New("target_auth requires TARGET_AUTH_KEY to be configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("TARGET_AUTH_KEY must be base64 encoded: " + err.Error())
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	}
+	return nil, errors.New("TARGET_AUTH_KEY must be 16, 24 or 32 bytes long")
+}
+
+func targetAuthCipher() (cipher.AEAD, error) {
+	key, err := targetAuthKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealTargetAuth encrypts the target credentials of the scan into
+// EncryptedTargetAuth, which is what gets stored.
+func (scan *Scan) sealTargetAuth() error {
+	if scan.TargetAuth == nil {
+		return nil
+	}
+	gcm, err := targetAuthCipher()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(scan.TargetAuth)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(scan.ID.Hex()))
+	scan.EncryptedTargetAuth = base64.StdEncoding.EncodeToString(sealed)
+	return nil
+}
+
+// openTargetAuth decrypts EncryptedTargetAuth into TargetAuth, for scans
+// loaded from the store that still have to run.
+func (scan *Scan) openTargetAuth() error {
+	if scan.EncryptedTargetAuth == "" {
+		return nil
+	}
+	gcm, err := targetAuthCipher()
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(scan.EncryptedTargetAuth)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return errors.New("Encrypted target_auth is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(scan.ID.Hex()))
+	if err != nil {
+		return err
+	}
+	scan.TargetAuth = new(TargetAuth)
+	return json.Unmarshal(plaintext, scan.TargetAuth)
+}
+
+func validateTargetAuth(auth *TargetAuth) error {
+	if auth == nil {
+		return nil
+	}
+	if auth.Username == "" {
+		return errors.New("target_auth requires a username")
+	}
+	_, err := targetAuthKey()
+	return err
+}
+
+// targetURL returns the URL Lighthouse audits. With target credentials
+// they are embedded in the URL, so that Chrome answers the basic auth
+// challenge of the target origin with them while requests to other origins,
+// e.g. CDNs and analytics, don't get them.
+func (scan *Scan) targetURL() string {
+	if scan.TargetAuth == nil {
+		return scan.URL
+	}
+	u, err := url.Parse(scan.URL)
+	if err != nil {
+		return scan.URL
+	}
+	u.User = url.UserPassword(scan.TargetAuth.Username, scan.TargetAuth.Password)
+	return u.String()
+}
+
+// scrubTargetAuth removes the credentials embedded by targetURL from a
+// report, which echoes the URL it audited.
+func (scan *Scan) scrubTargetAuth(report []byte) []byte {
+	if scan.TargetAuth == nil {
+		return report
+	}
+	userinfo := url.UserPassword(scan.TargetAuth.Username, scan.TargetAuth.Password).String() + "@"
+	return bytes.Replace(report, []byte(userinfo), nil, -1)
+}
+
+// lighthouseFromEnv runs the Lighthouse CLI given as its only argument with
+// the arguments in WEBSU_LIGHTHOUSE_ARGS. It keeps target credentials off
+// the command line, where any user of the machine could read them.
+const lighthouseFromEnv = `process.argv = process.argv.slice(0, 2).concat(JSON.parse(process.env.WEBSU_LIGHTHOUSE_ARGS));
+delete process.env.WEBSU_LIGHTHOUSE_ARGS;
+require(process.argv[1]);`
+
+// lighthouseCommand returns the command running Lighthouse with args. Scans
+// with target credentials pass args through the environment.
+func (scan *Scan) lighthouseCommand(args []string) (*exec.Cmd, error) {
+	if scan.TargetAuth == nil {
+		return exec.Command("lighthouse", args...), nil
+	}
+	bin, err := exec.LookPath("lighthouse")
+	if err != nil {
+		return nil, err
+	}
+	// The lighthouse binary is a symlink to the script of the CLI.
+	cli, err := filepath.EvalSymlinks(bin)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("node", "-e", lighthouseFromEnv, cli)
+	cmd.Env = append(os.Environ(), "WEBSU_LIGHTHOUSE_ARGS="+string(encoded))
+	return cmd, nil
+}
+
+// redactArgs returns a copy of lighthouse arguments that is safe to log or
+// return to clients.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if u, err := url.Parse(arg); err == nil && u.User != nil {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+			arg = u.String()
+		}
+		redacted[i] = arg
+	}
+	return redacted
+}
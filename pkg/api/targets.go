@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// blockedNetworks are the address ranges scans must not reach, so the API
+// can't be used to probe the network it runs in.
+var blockedNetworks = parseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, networks[i], _ = net.ParseCIDR(cidr)
+	}
+	return networks
+}
+
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPatterns returns the comma separated host patterns of an env var.
+// A pattern like *.example.com matches the subdomains of example.com,
+// other patterns match the host exactly.
+func hostPatterns(env string) []string {
+	patterns := []string{}
+	for _, p := range strings.Split(os.Getenv(env), ",") {
+		if p = strings.ToLower(strings.TrimSpace(p)); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+func matchesHost(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if p == host || strings.HasPrefix(p, "*.") && strings.HasSuffix(host, p[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScanTarget refuses scans of hosts in SCAN_DENIED_HOSTS and of
+// hosts that resolve, or are overridden, to private, loopback or link-local
+// addresses, unless the host is in SCAN_ALLOWED_HOSTS. Hosts that don't
+// resolve are let through, verifyScanTarget refuses them once the scan runs.
+//
+// Only the scanned host is checked. Redirects and subresources of the page
+// are not. Webhook URLs are checked the same way.
+func validateScanTarget(rawURL string, overrides map[string]string) error {
+	return checkScanTarget(rawURL, overrides, false)
+}
+
+// verifyScanTarget repeats the checks of validateScanTarget when the scan
+// starts, since the host may resolve to another address than when the scan
+// was requested. Hosts that don't resolve are refused.
+func verifyScanTarget(rawURL string, overrides map[string]string) error {
+	return checkScanTarget(rawURL, overrides, true)
+}
+
+func checkScanTarget(rawURL string, overrides map[string]string, mustResolve bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+	if matchesHost(hostPatterns("SCAN_DENIED_HOSTS"), host) {
+		return errors.New("Scanning " + host + " is not allowed")
+	}
+	if matchesHost(hostPatterns("SCAN_ALLOWED_HOSTS"), host) {
+		return nil
+	}
+	for overridden, ip := range overrides {
+		if isBlockedIP(net.ParseIP(ip)) {
+			return errors.New("Host override " + overridden + " must not map to the private address " + ip)
+		}
+	}
+	ips := []net.IP{}
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else if ip, ok := overrides[host]; ok {
+		ips = append(ips, net.ParseIP(ip))
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil && mustResolve {
+			return errors.New("Scanning " + host + " failed, it does not resolve: " + err.Error())
+		} else if err != nil {
+			return nil
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if isBlockedIP(ip) {
+			return errors.New("Scanning " + host + " is not allowed, it resolves to the private address " + ip.String())
+		}
+	}
+	return nil
+}
+
+// blockedTargetError reports a connection refused because its address is
+// private, see targetDialContext.
+type blockedTargetError struct {
+	host string
+	ip   net.IP
+}
+
+func (e *blockedTargetError) Error() string {
+	return "Connecting to " + e.host + " is not allowed, it resolves to the private address " + e.ip.String()
+}
+
+// targetDialContext dials like net.Dialer but applies the checks of
+// validateScanTarget to the address it actually connects to, so a host
+// can't pass validation and resolve to a private address later.
+func targetDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	host = strings.ToLower(host)
+	if matchesHost(hostPatterns("SCAN_DENIED_HOSTS"), host) {
+		return nil, errors.New("Connecting to " + host + " is not allowed")
+	}
+	allowed := matchesHost(hostPatterns("SCAN_ALLOWED_HOSTS"), host)
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	for _, a := range addrs {
+		if !allowed && isBlockedIP(a.IP) {
+			err = &blockedTargetError{host, a.IP}
+			continue
+		}
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(a.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
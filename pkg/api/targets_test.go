@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestVerifyScanTargetMustResolve(t *testing.T) {
+	if err := validateScanTarget("http://unreachable.invalid", nil); err != nil {
+		t.Errorf("Expected an unresolvable host to pass validation. Got %v", err)
+	}
+	if err := verifyScanTarget("http://unreachable.invalid", nil); err == nil {
+		t.Errorf("Expected an unresolvable host to be refused when the scan runs")
+	}
+}
+
+func TestTargetDialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{DialContext: targetDialContext}}
+
+	_, err := client.Get(server.URL)
+	var blocked *blockedTargetError
+	if !errors.As(err, &blocked) {
+		t.Errorf("Expected the loopback address to be refused. Got %v", err)
+	}
+
+	os.Setenv("SCAN_ALLOWED_HOSTS", "127.0.0.1")
+	defer os.Unsetenv("SCAN_ALLOWED_HOSTS")
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected an allowed host to be reachable. Got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultThirdPartyDays = 30
+
+// ThirdPartyImpact is the cost of a third-party vendor, e.g. Google Tag
+// Manager, on a scan. Times are in milliseconds, sizes in bytes.
+type ThirdPartyImpact struct {
+	Entity         string  `json:"entity" bson:"entity"`
+	TransferSize   float64 `json:"transfer_size" bson:"transfer_size"`
+	BlockingTime   float64 `json:"blocking_time" bson:"blocking_time"`
+	MainThreadTime float64 `json:"main_thread_time" bson:"main_thread_time"`
+}
+
+// ThirdPartyTrend is the series of the impact of a third-party vendor on
+// a URL, one point per scan that loaded it, oldest first.
+type ThirdPartyTrend struct {
+	URL    string            `json:"url"`
+	Entity string            `json:"entity"`
+	Days   int               `json:"days"`
+	Points []ThirdPartyPoint `json:"points"`
+}
+
+type ThirdPartyPoint struct {
+	ScanID    primitive.ObjectID `json:"scan_id"`
+	CreatedAt time.Time          `json:"created_at"`
+	ThirdPartyImpact
+}
+
+// ThirdParties returns the entries of the third-party-summary audit.
+func (r *LighthouseReport) ThirdParties() ([]ThirdPartyImpact, error) {
+	var items []struct {
+		// Entity is an object with the vendor name in text in current
+		// Lighthouse versions and the plain name in older ones.
+		Entity         json.RawMessage `json:"entity"`
+		TransferSize   float64         `json:"transferSize"`
+		BlockingTime   float64         `json:"blockingTime"`
+		MainThreadTime float64         `json:"mainThreadTime"`
+	}
+	if err := r.auditItems("third-party-summary", &items); err != nil {
+		return nil, err
+	}
+	impacts := []ThirdPartyImpact{}
+	for _, item := range items {
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(item.Entity, &entity); err != nil {
+			if err := json.Unmarshal(item.Entity, &entity.Text); err != nil {
+				return nil, err
+			}
+		}
+		impacts = append(impacts, ThirdPartyImpact{
+			Entity:         entity.Text,
+			TransferSize:   item.TransferSize,
+			BlockingTime:   item.BlockingTime,
+			MainThreadTime: item.MainThreadTime,
+		})
+	}
+	return impacts, nil
+}
+
+// getThirdPartyTrend returns the trend of the impact of the third-party
+// ?entity on ?url over the last ?days days, 30 by default.
+func (a *App) getThirdPartyTrend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	days, err := parseTrendQuery(r, defaultThirdPartyDays, "url", "entity")
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	trend := ThirdPartyTrend{URL: query.Get("url"), Entity: query.Get("entity"), Days: days}
+	filter := bson.M{"url": trend.URL, "third_parties.entity": trend.Entity}
+	scans, err := findTrendScans(QueryTrends, filter, days, "third_parties")
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	trend.Points = []ThirdPartyPoint{}
+	for _, scan := range scans {
+		for _, impact := range scan.ThirdParties {
+			if impact.Entity == trend.Entity {
+				trend.Points = append(trend.Points, ThirdPartyPoint{
+					ScanID:           scan.ID,
+					CreatedAt:        scan.CreatedAt,
+					ThirdPartyImpact: impact,
+				})
+				break
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(&trend)
+}
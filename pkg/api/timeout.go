@@ -0,0 +1,29 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxScanTimeout is the longest timeout in seconds a scan may ask for.
+const maxScanTimeout = 1800
+
+// scanTimeout returns the default scan timeout configured in SCAN_TIMEOUT
+// seconds, 300 by default.
+func scanTimeout() time.Duration {
+	timeout, err := strconv.Atoi(os.Getenv("SCAN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 300
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// timeout returns how long Lighthouse may run for the scan before it is
+// killed.
+func (scan *Scan) timeout() time.Duration {
+	if scan.TimeoutSeconds > 0 {
+		return time.Duration(scan.TimeoutSeconds) * time.Second
+	}
+	return scanTimeout()
+}
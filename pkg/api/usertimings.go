@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultUserTimingDays = 30
+
+// UserTiming is a performance.mark or performance.measure entry recorded
+// by the page during a scan. Times are in milliseconds, Duration is only
+// set for measures.
+type UserTiming struct {
+	Name      string   `json:"name" bson:"name"`
+	Type      string   `json:"type" bson:"type"`
+	StartTime float64  `json:"start_time" bson:"start_time"`
+	Duration  *float64 `json:"duration,omitempty" bson:"duration,omitempty"`
+}
+
+// UserTimingTrend is the series of a user timing of a URL, one point per
+// scan that recorded it, oldest first.
+type UserTimingTrend struct {
+	URL    string            `json:"url"`
+	Name   string            `json:"name"`
+	Days   int               `json:"days"`
+	Points []UserTimingPoint `json:"points"`
+}
+
+type UserTimingPoint struct {
+	ScanID    primitive.ObjectID `json:"scan_id"`
+	CreatedAt time.Time          `json:"created_at"`
+	StartTime float64            `json:"start_time"`
+	Duration  *float64           `json:"duration,omitempty"`
+}
+
+// UserTimings returns the entries of the user-timings audit.
+func (r *LighthouseReport) UserTimings() ([]UserTiming, error) {
+	var items []struct {
+		Name       string   `json:"name"`
+		TimingType string   `json:"timingType"`
+		StartTime  float64  `json:"startTime"`
+		Duration   *float64 `json:"duration"`
+	}
+	if err := r.auditItems("user-timings", &items); err != nil {
+		return nil, err
+	}
+	timings := []UserTiming{}
+	for _, item := range items {
+		timings = append(timings, UserTiming{
+			Name:      item.Name,
+			Type:      item.TimingType,
+			StartTime: item.StartTime,
+			Duration:  item.Duration,
+		})
+	}
+	return timings, nil
+}
+
+// getUserTimingTrend returns the trend of the user timing ?name of ?url
+// over the last ?days days, 30 by default.
+func (a *App) getUserTimingTrend(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	days, err := parseTrendQuery(r, defaultUserTimingDays, "url", "name")
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query()
+	trend := UserTimingTrend{URL: query.Get("url"), Name: query.Get("name"), Days: days}
+	filter := bson.M{"url": trend.URL, "user_timings.name": trend.Name}
+	scans, err := findTrendScans(QueryTrends, filter, days, "user_timings")
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	trend.Points = []UserTimingPoint{}
+	for _, scan := range scans {
+		for _, timing := range scan.UserTimings {
+			if timing.Name == trend.Name {
+				trend.Points = append(trend.Points, UserTimingPoint{
+					ScanID:    scan.ID,
+					CreatedAt: scan.CreatedAt,
+					StartTime: timing.StartTime,
+					Duration:  timing.Duration,
+				})
+				break
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(&trend)
+}
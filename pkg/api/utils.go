@@ -6,11 +6,128 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
 )
 
+// parsePagination reads the 1-based ?page and the ?limit query parameters.
+func parsePagination(r *http.Request) (page int, limit int, err error) {
+	query := r.URL.Query()
+	page, limit = 1, defaultPageLimit
+	if p := query.Get("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+	}
+	if l := query.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be a number between 1 and %d", maxPageLimit)
+		}
+	}
+	return page, limit, nil
+}
+
+// parseTrendQuery checks that the required query parameters of a trend
+// endpoint are given and reads ?days, the number of days back from now the
+// trend covers, which is defaultDays unless given.
+func parseTrendQuery(r *http.Request, defaultDays int, required ...string) (int, error) {
+	query := r.URL.Query()
+	for _, param := range required {
+		if query.Get(param) != "" {
+			continue
+		}
+		if len(required) == 1 {
+			return 0, errors.New("Query parameter " + param + " is required")
+		}
+		last := len(required) - 1
+		return 0, errors.New("Query parameters " + strings.Join(required[:last], ", ") + " and " +
+			required[last] + " are required")
+	}
+	d := query.Get("days")
+	if d == "" {
+		return defaultDays, nil
+	}
+	days, err := strconv.Atoi(d)
+	if err != nil || days <= 0 {
+		return 0, errors.New("days must be a positive number")
+	}
+	return days, nil
+}
+
+// parseScanQuery reads the filters of GET /scans: ?url matches exactly,
+// ?url_prefix the start of the URL, ?created_after and ?created_before are
+// RFC 3339 times, ?status a scan status and ?min_performance and
+// ?max_performance bound the performance score between 0 and 1.
+func parseScanQuery(r *http.Request) (ScanQuery, error) {
+	query := r.URL.Query()
+	q := ScanQuery{URL: query.Get("url"), URLPrefix: query.Get("url_prefix"), Status: query.Get("status"),
+		Tag: query.Get("tag")}
+	if q.URL != "" && q.URLPrefix != "" {
+		return q, errors.New("url and url_prefix can't be combined")
+	}
+	var err error
+	if p := query.Get("project"); p != "" {
+		if q.Project, err = primitive.ObjectIDFromHex(p); err != nil {
+			return q, errors.New("project must be the ID of a project")
+		}
+	}
+	for param, t := range map[string]*time.Time{"created_after": &q.CreatedAfter, "created_before": &q.CreatedBefore} {
+		if v := query.Get(param); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return q, errors.New(param + " must be an RFC 3339 time, e.g. 2020-06-01T00:00:00Z")
+			}
+		}
+	}
+	switch q.Status {
+	case "", ScanPending, ScanRunning, ScanSucceeded, ScanFailed, ScanCancelled, ScanTimedOut:
+	default:
+		return q, errors.New("Unknown status " + q.Status)
+	}
+	for param, score := range map[string]**float64{"min_performance": &q.MinPerformance, "max_performance": &q.MaxPerformance} {
+		if v := query.Get(param); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 || f > 1 {
+				return q, errors.New(param + " must be a number between 0 and 1")
+			}
+			*score = &f
+		}
+	}
+	return q, nil
+}
+
+// parseSort reads the ?sort query parameter, a comma separated list of
+// fields that are sorted in descending order when prefixed with "-".
+func parseSort(r *http.Request, defaultSort string, allowed map[string]bool) (bson.D, error) {
+	param := r.URL.Query().Get("sort")
+	if param == "" {
+		param = defaultSort
+	}
+	sort := bson.D{}
+	for _, field := range strings.Split(param, ",") {
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			field, order = field[1:], -1
+		}
+		if !allowed[field] {
+			return nil, fmt.Errorf("Sorting by %q is not supported", field)
+		}
+		sort = append(sort, bson.E{Key: field, Value: order})
+	}
+	return sort, nil
+}
+
 type malformedRequest struct {
 	status int
+	code   string
 	msg    string
 }
 
@@ -18,6 +135,18 @@ func (mr *malformedRequest) Error() string {
 	return mr.msg
 }
 
+// writeDecodeError responds with the status and message of a malformed
+// request, or with a generic internal server error for any other error.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var mr *malformedRequest
+	if errors.As(err, &mr) {
+		writeProblem(w, r, mr.status, mr.code, mr.msg)
+	} else {
+		httpLog.Errorf("%s", err.Error())
+		writeError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
 func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	r.Body = http.MaxBytesReader(w, r.Body, 1048576)
 
@@ -32,28 +161,28 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 		switch {
 		case errors.As(err, &syntaxError):
 			msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
-			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeInvalidJSON, msg: msg}
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
 			msg := fmt.Sprintf("Request body contains badly-formed JSON")
-			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeInvalidJSON, msg: msg}
 
 		case errors.As(err, &unmarshalTypeError):
 			msg := fmt.Sprintf("Request body contains an invalid value for the %q field (at position %d)", unmarshalTypeError.Field, unmarshalTypeError.Offset)
-			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeInvalidFieldValue, msg: msg}
 
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			msg := fmt.Sprintf("Request body contains unknown field %s", fieldName)
-			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeUnknownField, msg: msg}
 
 		case errors.Is(err, io.EOF):
 			msg := "Request body must not be empty"
-			return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+			return &malformedRequest{status: http.StatusBadRequest, code: CodeEmptyBody, msg: msg}
 
 		case err.Error() == "http: request body too large":
 			msg := "Request body must not be larger than 1MB"
-			return &malformedRequest{status: http.StatusRequestEntityTooLarge, msg: msg}
+			return &malformedRequest{status: http.StatusRequestEntityTooLarge, code: CodeBodyTooLarge, msg: msg}
 
 		default:
 			return err
@@ -62,7 +191,7 @@ func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 
 	if dec.More() {
 		msg := "Request body must only contain a single JSON object"
-		return &malformedRequest{status: http.StatusBadRequest, msg: msg}
+		return &malformedRequest{status: http.StatusBadRequest, code: CodeMultipleJSONValues, msg: msg}
 	}
 
 	return nil
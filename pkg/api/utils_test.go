@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrendQuery(t *testing.T) {
+	for _, c := range []struct {
+		query string
+		days  int
+		err   string
+	}{
+		{"?url=a&name=b", 30, ""},
+		{"?url=a&name=b&days=7", 7, ""},
+		{"?url=a&name=b&days=0", 0, "days must be a positive number"},
+		{"?url=a&name=b&days=week", 0, "days must be a positive number"},
+		{"?url=a", 0, "Query parameters url and name are required"},
+	} {
+		days, err := parseTrendQuery(httptest.NewRequest("GET", "/trend"+c.query, nil), 30, "url", "name")
+		if days != c.days || (err == nil) != (c.err == "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Expected %d, %q for %s. Got %d, %v", c.days, c.err, c.query, days, err)
+		}
+	}
+	if _, err := parseTrendQuery(httptest.NewRequest("GET", "/trend", nil), 30, "url"); err == nil ||
+		err.Error() != "Query parameter url is required" {
+		t.Errorf("Expected url to be required. Got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+)
+
+// ScanValidation describes the Lighthouse invocation a scan request would
+// run, as returned by POST /scans/validate. Command is null for scans that
+// don't run the local Lighthouse CLI.
+type ScanValidation struct {
+	URL        string   `json:"url"`
+	RunnerType string   `json:"runner_type"`
+	Command    []string `json:"command"`
+}
+
+// validateScanURL checks that rawURL is an absolute http or https URL.
+func validateScanURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("URL must not be empty")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return errors.New("URL " + rawURL + " could not be parsed: " + err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("URL " + rawURL + " must use the http or https scheme")
+	}
+	if u.Hostname() == "" {
+		return errors.New("URL " + rawURL + " must contain a host")
+	}
+	return nil
+}
+
+// requested returns a scan with only the fields clients may set when
+// requesting a scan. Everything else, e.g. the report locations or scores,
+// is managed by the API and must not be taken from the request.
+func (scan *Scan) requested() Scan {
+	return Scan{
+		URL:             scan.URL,
+		ChromeProfile:   scan.ChromeProfile,
+		ProtocolPresets: scan.ProtocolPresets,
+		HostOverrides:   scan.HostOverrides,
+		Project:         scan.Project,
+		Tags:            scan.Tags,
+		Label:           scan.Label,
+		Notes:           scan.Notes,
+		TimeoutSeconds:  scan.TimeoutSeconds,
+		RunnerType:      scan.RunnerType,
+		Options:         scan.Options,
+		TargetAuth:      scan.TargetAuth,
+	}
+}
+
+func (scan *Scan) validate() error {
+	if err := validateScanURL(scan.URL); err != nil {
+		return err
+	}
+	switch scan.ChromeProfile {
+	case "", ChromeProfileFresh, ChromeProfilePersistent:
+	default:
+		return errors.New("Unknown chrome_profile " + scan.ChromeProfile + ", expected fresh or persistent")
+	}
+	if err := validateProtocolPresets(scan.ProtocolPresets); err != nil {
+		return err
+	}
+	if err := validateHostOverrides(scan.HostOverrides); err != nil {
+		return err
+	}
+	if err := validateScanTarget(scan.URL, scan.HostOverrides); err != nil {
+		return err
+	}
+	if err := scan.Options.validate(); err != nil {
+		return err
+	}
+	if err := scan.validateRunner(); err != nil {
+		return err
+	}
+	if err := validateTags(scan.Tags); err != nil {
+		return err
+	}
+	if err := validateProject(scan.Project); err != nil {
+		return err
+	}
+	if scan.TimeoutSeconds < 0 || scan.TimeoutSeconds > maxScanTimeout {
+		return fmt.Errorf("timeout_seconds must be between 1 and %d", maxScanTimeout)
+	}
+	return validateTargetAuth(scan.TargetAuth)
+}
+
+func (a *App) validateScan(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var request Scan
+	if err := decodeJSONBody(w, r, &request); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	scan := request.requested()
+	if err := scan.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	validation := ScanValidation{URL: scan.URL, RunnerType: scan.runnerType()}
+	if validation.RunnerType == RunnerLighthouse {
+		// With a Chrome pool the port is only known once an instance is
+		// acquired, so the first port of the pool is shown.
+		port := 0
+		if scan.usesChromePool() {
+			port = chromePool.basePort
+		}
+		outputDir := filepath.Join(reportsBaseDir(), "<scan-id>")
+		validation.Command = append([]string{"lighthouse"}, redactArgs(lighthouseArgs(&scan, port, outputDir))...)
+	}
+	json.NewEncoder(w).Encode(&validation)
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// WebhookSignatureHeader carries the hex encoded HMAC-SHA256 of the
+	// request body, keyed with the secret of the webhook.
+	WebhookSignatureHeader = "X-Websu-Signature"
+	EventScanCompleted     = "scan.completed"
+	// EventPWAInstallabilityChanged is sent when the page of a scan became
+	// installable or stopped being installable since the previous scan of
+	// its URL.
+	EventPWAInstallabilityChanged = "pwa.installability_changed"
+	// EventSLOBreached is sent when the service starts missing one of its
+	// own objectives, see SLO.
+	EventSLOBreached = "slo.breached"
+	webhookAttempts  = 5
+)
+
+// webhookEvents are the events webhooks can subscribe to.
+var webhookEvents = map[string]bool{EventScanCompleted: true, EventPWAInstallabilityChanged: true,
+	EventSLOBreached: true}
+
+// webhookBackoff is the delay before the first retry of a failed delivery.
+// It doubles with every further attempt.
+var webhookBackoff = time.Second
+
+// webhookClient only connects to public addresses, see targetDialContext,
+// and doesn't follow redirects, which count as failed deliveries.
+var webhookClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{DialContext: targetDialContext},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Webhook is a callback URL that is notified of the Events it subscribed
+// to, by default whenever a scan finishes, whether it succeeded or failed.
+// The secret is only returned when the webhook is created.
+type Webhook struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id"`
+	URL       string             `json:"url" bson:"url"`
+	Secret    string             `json:"secret,omitempty" bson:"secret"`
+	Events    []string           `json:"events,omitempty" bson:"events,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// WebhookEvent is the body POSTed to webhooks. Installable is only set for
+// EventPWAInstallabilityChanged, Stats only for EventSLOBreached, which has
+// no scan.
+type WebhookEvent struct {
+	Event       string `json:"event"`
+	Scan        *Scan  `json:"scan,omitempty"`
+	Installable *bool  `json:"installable,omitempty"`
+	Stats       *Stats `json:"stats,omitempty"`
+}
+
+func (hook *Webhook) validate() error {
+	for _, event := range hook.Events {
+		if !webhookEvents[event] {
+			return errors.New("Unknown event " + event + ", expected " + EventScanCompleted + ", " +
+				EventPWAInstallabilityChanged + " or " + EventSLOBreached)
+		}
+	}
+	if err := validateScanURL(hook.URL); err != nil {
+		return err
+	}
+	return validateScanTarget(hook.URL, nil)
+}
+
+// subscribes reports whether the webhook is notified of event.
+func (hook *Webhook) subscribes(event string) bool {
+	if len(hook.Events) == 0 {
+		return event == EventScanCompleted
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func GetAllWebhooks() ([]Webhook, error) {
+	hooks := []Webhook{}
+	collection := DB.Database("websu").Collection("webhooks")
+	c := context.TODO()
+	cursor, err := collection.Find(c, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(c, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func GetWebhookByObjectIDHex(hex string) (Webhook, error) {
+	var hook Webhook
+	oid, err := parseObjectID(hex)
+	if err != nil {
+		return hook, err
+	}
+	collection := DB.Database("websu").Collection("webhooks")
+	err = collection.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&hook)
+	return hook, err
+}
+
+func (hook *Webhook) Insert() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("webhooks")
+	_, err := collection.InsertOne(ctx, hook)
+	return err
+}
+
+func (hook *Webhook) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("webhooks")
+	update := bson.M{"$set": bson.M{"url": hook.URL, "events": hook.Events}}
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": hook.ID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errNotFound("Webhook", hook.ID)
+	}
+	return nil
+}
+
+func (hook *Webhook) Delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	collection := DB.Database("websu").Collection("webhooks")
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": hook.ID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errNotFound("Webhook", hook.ID)
+	}
+	return nil
+}
+
+// SignWebhookBody returns the signature of body sent in
+// WebhookSignatureHeader.
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver POSTs body to the webhook. Network errors and 5xx responses are
+// retried with exponential backoff, other responses are final.
+func (hook *Webhook) deliver(body []byte) error {
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var req *http.Request
+		req, err = http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookSignatureHeader, SignWebhookBody(hook.Secret, body))
+		var resp *http.Response
+		resp, err = webhookClient.Do(req)
+		var blocked *blockedTargetError
+		if errors.As(err, &blocked) {
+			return err
+		} else if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 500 {
+			if resp.StatusCode >= 300 {
+				return errors.New("Webhook responded with " + resp.Status)
+			}
+			return nil
+		}
+		err = errors.New("Webhook responded with " + resp.Status)
+	}
+	return err
+}
+
+// notifyWebhooks sends the finished scan to the webhooks subscribed to
+// scan.completed, and to those subscribed to pwa.installability_changed
+// when its install readiness changed.
+func notifyWebhooks(scan *Scan) {
+	hooks, err := GetAllWebhooks()
+	if err != nil {
+		storeLog.Errorf("Loading webhooks failed: %v", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	events := []WebhookEvent{{Event: EventScanCompleted, Scan: scan}}
+	if changed, err := installabilityChanged(scan); err != nil {
+		storeLog.Errorf("Loading the previous scan of scan %s failed: %v", scan.ID.Hex(), err)
+	} else if changed {
+		events = append(events, WebhookEvent{Event: EventPWAInstallabilityChanged, Scan: scan,
+			Installable: scan.installable()})
+	}
+	for _, event := range events {
+		sendWebhookEvent(hooks, event)
+	}
+}
+
+// sendWebhookEvent delivers event to those of hooks that subscribed to it.
+func sendWebhookEvent(hooks []Webhook, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		engineLog.Errorf("Encoding webhook event %s failed: %v", event.Event, err)
+		return
+	}
+	for i := range hooks {
+		if !hooks[i].subscribes(event.Event) {
+			continue
+		}
+		go func(hook *Webhook) {
+			if err := hook.deliver(body); err != nil {
+				engineLog.Warnf("Delivering %s to webhook %s failed: %v", event.Event, hook.ID.Hex(), err)
+			}
+		}(&hooks[i])
+	}
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func (a *App) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	hooks, err := GetAllWebhooks()
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	json.NewEncoder(w).Encode(&hooks)
+}
+
+// createWebhook registers a webhook. A secret is generated unless one is
+// given.
+func (a *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var hook Webhook
+	if err := decodeJSONBody(w, r, &hook); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := hook.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hook.Secret == "" {
+		var err error
+		if hook.Secret, err = newWebhookSecret(); err != nil {
+			writeError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	hook.ID = primitive.NewObjectID()
+	hook.CreatedAt = time.Now()
+	if err := hook.Insert(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&hook)
+}
+
+func (a *App) getWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	hook, err := GetWebhookByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	json.NewEncoder(w).Encode(&hook)
+}
+
+func (a *App) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	hook, err := GetWebhookByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	var update Webhook
+	if err := decodeJSONBody(w, r, &update); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if err := update.validate(); err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook.URL = update.URL
+	hook.Events = update.Events
+	if err := hook.Update(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	json.NewEncoder(w).Encode(&hook)
+}
+
+func (a *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	hook, err := GetWebhookByObjectIDHex(mux.Vars(r)["id"])
+	if err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	if err := hook.Delete(); err != nil {
+		writeStoreError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(&Webhook{})
+}
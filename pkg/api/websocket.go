@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const socketPingInterval = 30 * time.Second
+
+// upgrader accepts connections from any origin, like the CORS policy of
+// the API.
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// getScanFeedSocket upgrades to a WebSocket that receives a WebhookEvent
+// for every scan that finishes running. Messages sent by the client are
+// ignored.
+func (a *App) getScanFeedSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		httpLog.Warnf("Upgrading to a WebSocket failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	completed := scanEvents.subscribeCompleted()
+	defer scanEvents.unsubscribeCompleted(completed)
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case scan := <-completed:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(WebhookEvent{Event: EventScanCompleted, Scan: scan}); err != nil {
+				return
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(10 * time.Second)
+			if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scanQueueSize is the number of jobs that can wait for a free worker
+// before new scans are rejected.
+const scanQueueSize = 1000
+
+var (
+	ErrQueueFull    = errors.New("Too many scans are queued, try again later")
+	ErrShuttingDown = errors.New("The server is shutting down, try again later")
+)
+
+type queuedScan struct {
+	job  Job
+	scan *Scan
+}
+
+// WorkerPool runs scan jobs in the background with a bounded number of
+// Lighthouse processes running at the same time. Jobs beyond that wait in
+// a queue.
+type WorkerPool struct {
+	queue chan queuedScan
+	// ctx is canceled to kill the running Lighthouse processes when they
+	// don't finish in time on shutdown.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping chan struct{}
+	mu       sync.RWMutex
+	closed   bool
+	wg       sync.WaitGroup
+	// running holds the functions canceling the running jobs.
+	running map[primitive.ObjectID]context.CancelFunc
+}
+
+// CreateWorkerPool starts a worker pool running MAX_CONCURRENT_SCANS scans
+// in parallel, 1 by default as Lighthouse results get noisy when scans
+// compete for CPU.
+func CreateWorkerPool() *WorkerPool {
+	size, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_SCANS"))
+	if err != nil || size <= 0 {
+		size = 1
+	}
+	return NewWorkerPool(size)
+}
+
+func NewWorkerPool(size int) *WorkerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WorkerPool{
+		queue:    make(chan queuedScan, scanQueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+		running:  make(map[primitive.ObjectID]context.CancelFunc),
+	}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for q := range p.queue {
+		select {
+		case <-p.stopping:
+			// The job stays queued and is picked up by Recover on the
+			// next start.
+			continue
+		default:
+		}
+		ctx, cancel := context.WithCancel(context.WithValue(p.ctx, poolContextKey{}, p.ctx))
+		p.mu.Lock()
+		p.running[q.job.ID] = cancel
+		p.mu.Unlock()
+		q.job.run(ctx, q.scan)
+		p.mu.Lock()
+		delete(p.running, q.job.ID)
+		p.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel kills the Lighthouse process of a running job. It reports whether
+// the job was running, in which case the job records its cancellation
+// itself when it stops. Queued jobs are cancelled in the store instead and
+// skipped once their turn comes.
+func (p *WorkerPool) Cancel(id primitive.ObjectID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cancel, ok := p.running[id]; ok {
+		cancel()
+		return true
+	}
+	return false
+}
+
+// Shutdown stops accepting scans, leaving the queued ones for the next
+// start. It waits for the running scans to finish until ctx is done, after
+// which their Lighthouse processes are killed and the scans are marked as
+// interrupted.
+func (p *WorkerPool) Shutdown(ctx context.Context) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stopping)
+	close(p.queue)
+	p.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		engineLog.Warnf("Scans did not finish in time, killing Lighthouse")
+		p.cancel()
+		<-done
+	}
+}
+
+// Enqueue stores a queued job for scan and hands it to the workers. It
+// returns ErrQueueFull when the queue has no room left.
+func (p *WorkerPool) Enqueue(scan *Scan) (*Job, error) {
+	job := &Job{
+		ID:        primitive.NewObjectID(),
+		Status:    JobQueued,
+		URL:       scan.URL,
+		ScanID:    scan.ID,
+		CreatedAt: time.Now(),
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		if err := scan.setStatus(ScanFailed, ErrShuttingDown.Error()); err != nil {
+			storeLog.Errorf("Marking scan %s as failed: %v", scan.ID.Hex(), err)
+		}
+		return nil, ErrShuttingDown
+	}
+	if err := job.Insert(); err != nil {
+		return nil, err
+	}
+	if err := p.push(*job, scan); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// requeue hands a job that is already stored as queued to the workers.
+func (p *WorkerPool) requeue(job Job, scan *Scan) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrShuttingDown
+	}
+	return p.push(job, scan)
+}
+
+// push hands the job to the workers, aborting it when the queue is full.
+// p.mu must be held.
+func (p *WorkerPool) push(job Job, scan *Scan) error {
+	select {
+	case p.queue <- queuedScan{job: job, scan: scan}:
+		return nil
+	default:
+		job.abort(scan, ErrQueueFull.Error())
+		return ErrQueueFull
+	}
+}